@@ -0,0 +1,184 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/libs/go/config"
+)
+
+const (
+	DEFAULT_ALLOWED_ORIGINS = "http://localhost:5173"
+	DEFAULT_ALLOWED_METHODS = "GET, POST, PUT, DELETE, OPTIONS, PATCH"
+	DEFAULT_ALLOWED_HEADERS = "Accept, Authorization, Content-Type, X-CSRF-Token"
+)
+
+// Policy decides which cross origin requests browsers may make. Origins are
+// either exact, e.g. "https://whatdoing.app", or match any subdomain, e.g.
+// "https://*.whatdoing.app". "*" allows every origin but cannot be combined
+// with credentials.
+type Policy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	MaxAge           time.Duration
+	AllowCredentials bool
+}
+
+// NewPolicyFromConfig reads the policy from the CORS_ALLOWED_ORIGINS,
+// CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS (comma separated), CORS_MAX_AGE
+// (duration) and CORS_ALLOW_CREDENTIALS (bool) env options. Unset options fall
+// back to the local development defaults.
+func NewPolicyFromConfig(c *config.Config) (*Policy, error) {
+	policy := &Policy{
+		AllowedOrigins:   splitList(valueOr(c.Get("CORS_ALLOWED_ORIGINS"), DEFAULT_ALLOWED_ORIGINS)),
+		AllowedMethods:   splitList(valueOr(c.Get("CORS_ALLOWED_METHODS"), DEFAULT_ALLOWED_METHODS)),
+		AllowedHeaders:   splitList(valueOr(c.Get("CORS_ALLOWED_HEADERS"), DEFAULT_ALLOWED_HEADERS)),
+		AllowCredentials: true,
+	}
+
+	if v := c.Get("CORS_MAX_AGE"); v != "" {
+		maxAge, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("cors: invalid max age: %v", err)
+		}
+		policy.MaxAge = maxAge
+	}
+
+	if v := c.Get("CORS_ALLOW_CREDENTIALS"); v != "" {
+		allowCredentials, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("cors: invalid allow credentials: %v", err)
+		}
+		policy.AllowCredentials = allowCredentials
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (p *Policy) Validate() error {
+	if p.MaxAge < 0 {
+		return fmt.Errorf("cors: negative max age")
+	}
+
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				return fmt.Errorf("cors: origin \"*\" cannot be used with credentials")
+			}
+			continue
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil {
+			return fmt.Errorf("cors: invalid origin %q: %v", origin, err)
+		}
+		if u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return fmt.Errorf("cors: origin %q must be <scheme>://<host>[:port]", origin)
+		}
+		if strings.Contains(u.Host, "*") && !strings.HasPrefix(u.Host, "*.") {
+			return fmt.Errorf("cors: origin %q may only use a wildcard as its first label", origin)
+		}
+	}
+
+	return nil
+}
+
+func (p *Policy) AllowOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		// The wildcard matches one or more labels, but not the bare domain.
+		suffix := "." + host
+		prefix := scheme + "://"
+		lower := strings.ToLower(origin)
+		if strings.HasPrefix(lower, prefix) &&
+			strings.HasSuffix(lower, strings.ToLower(suffix)) &&
+			len(lower) > len(prefix)+len(suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handler sets the CORS headers for allowed origins only and answers
+// preflight requests itself.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Origin header, caches must not share it
+		// across origins.
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if p.AllowOrigin(origin) {
+			if p.allowAny() {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if p.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+				if p.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+				}
+			}
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Policy) allowAny() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(v string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func valueOr(v, fallback string) string {
+	if strings.TrimSpace(v) == "" {
+		return fallback
+	}
+	return v
+}
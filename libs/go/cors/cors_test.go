@@ -0,0 +1,77 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowOrigin(t *testing.T) {
+	p := &Policy{
+		AllowedOrigins: []string{"http://localhost:5173", "https://*.whatdoing.app"},
+	}
+
+	assert.True(t, p.AllowOrigin("http://localhost:5173"))
+	assert.True(t, p.AllowOrigin("https://www.whatdoing.app"))
+	assert.True(t, p.AllowOrigin("https://a.b.whatdoing.app"))
+
+	assert.False(t, p.AllowOrigin(""))
+	assert.False(t, p.AllowOrigin("http://localhost:3000"))
+	assert.False(t, p.AllowOrigin("https://whatdoing.app"))
+	assert.False(t, p.AllowOrigin("http://www.whatdoing.app"))
+	assert.False(t, p.AllowOrigin("https://evilwhatdoing.app"))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, (&Policy{AllowedOrigins: []string{"https://*.whatdoing.app"}}).Validate())
+	assert.NoError(t, (&Policy{AllowedOrigins: []string{"*"}}).Validate())
+
+	assert.Error(t, (&Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true}).Validate())
+	assert.Error(t, (&Policy{AllowedOrigins: []string{"whatdoing.app"}}).Validate())
+	assert.Error(t, (&Policy{AllowedOrigins: []string{"https://whatdoing.app/"}}).Validate())
+	assert.Error(t, (&Policy{AllowedOrigins: []string{"https://www.*.whatdoing.app"}}).Validate())
+}
+
+func TestHandler(t *testing.T) {
+	p := &Policy{
+		AllowedOrigins:   []string{"http://localhost:5173"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization"},
+		MaxAge:           time.Minute,
+		AllowCredentials: true,
+	}
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "http://localhost:5173")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "http://localhost:5173", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "http://evil.example")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	r = httptest.NewRequest(http.MethodOptions, "/", nil)
+	r.Header.Set("Origin", "http://localhost:5173")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "60", w.Header().Get("Access-Control-Max-Age"))
+}
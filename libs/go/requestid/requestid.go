@@ -0,0 +1,82 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+const HEADER = "X-Request-Id"
+
+const maxLength = 128
+
+type ctxKey int
+
+const (
+	requestIdCtxKey ctxKey = iota
+)
+
+// New returns a random 128 bit id in hex.
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid only accepts ids that are safe to copy into logs and headers as is.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware keeps a valid incoming X-Request-Id, or generates one, and makes
+// it available on the request header, the request context and the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HEADER)
+		if !Valid(id) {
+			id = New()
+		}
+
+		r.Header.Set(HEADER, id)
+		w.Header().Set(HEADER, id)
+
+		next.ServeHTTP(w, r.WithContext(WithContext(r.Context(), id)))
+	})
+}
+
+func WithContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdCtxKey, id)
+}
+
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdCtxKey).(string)
+	return id
+}
+
+// Printf logs like log.Printf, prefixed with the request id in ctx if any.
+func Printf(ctx context.Context, format string, v ...any) {
+	Logf(FromContext(ctx), format, v...)
+}
+
+// Logf logs like log.Printf, prefixed with id if it is not empty.
+func Logf(id string, format string, v ...any) {
+	if id == "" {
+		log.Printf(format, v...)
+		return
+	}
+	log.Printf("request_id=%s %s", id, fmt.Sprintf(format, v...))
+}
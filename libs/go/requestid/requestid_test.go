@@ -0,0 +1,43 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid(New()))
+	assert.True(t, Valid("3f2b-abc_1.2:3"))
+
+	assert.False(t, Valid(""))
+	assert.False(t, Valid("a b"))
+	assert.False(t, Valid("a\nrequest_id=b"))
+	assert.False(t, Valid(strings.Repeat("a", maxLength+1)))
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+		assert.Equal(t, seen, r.Header.Get(HEADER))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(HEADER, "abc")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, "abc", seen)
+	assert.Equal(t, "abc", w.Header().Get(HEADER))
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(HEADER, "bad id")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.NotEqual(t, "bad id", seen)
+	assert.True(t, Valid(seen))
+	assert.Equal(t, seen, w.Header().Get(HEADER))
+}
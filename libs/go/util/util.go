@@ -45,16 +45,70 @@ func ParseRequestUrl(r *http.Request) (prefix, pathValue string, ok bool) {
 	return strings.TrimSpace(before), strings.TrimSpace(after), true
 }
 
+// HasScope reports whether the granted scopes in actual, separated by commas
+// or spaces, cover the expected scope. A granted scope ending in ":*" covers
+// every scope under that resource, e.g. "anime:*" covers "anime:read", and
+// "*" covers everything. An empty expected scope is always covered.
 func HasScope(expected, actual string) bool {
-	scopes := strings.SplitSeq(actual, ",")
-	for v := range scopes {
-		if expected == strings.ToLower(strings.TrimSpace(v)) {
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	if expected == "" {
+		return true
+	}
+
+	for _, granted := range ParseScopes(actual) {
+		if ScopeMatches(granted, expected) {
 			return true
 		}
 	}
 	return false
 }
 
+// HasAllScopes reports whether every expected scope is covered.
+func HasAllScopes(expected []string, actual string) bool {
+	for _, v := range expected {
+		if !HasScope(v, actual) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasAnyScope reports whether at least one expected scope is covered. An
+// empty list is always covered.
+func HasAnyScope(expected []string, actual string) bool {
+	if len(expected) == 0 {
+		return true
+	}
+	for _, v := range expected {
+		if HasScope(v, actual) {
+			return true
+		}
+	}
+	return false
+}
+
+func ParseScopes(actual string) []string {
+	scopes := make([]string, 0)
+	for v := range strings.FieldsFuncSeq(actual, func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		scopes = append(scopes, strings.ToLower(v))
+	}
+	return scopes
+}
+
+func ScopeMatches(granted, expected string) bool {
+	granted = strings.ToLower(granted)
+	expected = strings.ToLower(expected)
+
+	if granted == "*" || granted == expected {
+		return true
+	}
+
+	resource, ok := strings.CutSuffix(granted, ":*")
+	return ok && strings.HasPrefix(expected, resource+":")
+}
+
 func SetCookie(w http.ResponseWriter, name, value string) {
 	// TODO: add expiration
 	http.SetCookie(w, &http.Cookie{
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasScope(t *testing.T) {
+	assert.True(t, HasScope("", ""))
+	assert.True(t, HasScope("", "anime:read"))
+	assert.True(t, HasScope("anime:read", "anime:read"))
+	assert.True(t, HasScope("anime:read", "user:read, ANIME:READ"))
+	assert.True(t, HasScope("anime:read", "user:read anime:read"))
+	assert.True(t, HasScope("anime:read", "anime:*"))
+	assert.True(t, HasScope("anime:read:own", "anime:*"))
+	assert.True(t, HasScope("anime:write", "*"))
+
+	assert.False(t, HasScope("anime:read", ""))
+	assert.False(t, HasScope("anime:write", "anime:read"))
+	assert.False(t, HasScope("animes:read", "anime:*"))
+	assert.False(t, HasScope("anime", "anime:*"))
+}
+
+func TestHasAllAndAnyScopes(t *testing.T) {
+	assert.True(t, HasAllScopes(nil, ""))
+	assert.True(t, HasAllScopes([]string{"anime:read", "anime:write"}, "anime:*"))
+	assert.False(t, HasAllScopes([]string{"anime:read", "anime:write"}, "anime:read"))
+
+	assert.True(t, HasAnyScope(nil, ""))
+	assert.True(t, HasAnyScope([]string{"anime:write", "catalog:admin"}, "catalog:admin"))
+	assert.False(t, HasAnyScope([]string{"anime:write", "catalog:admin"}, "anime:read"))
+}
@@ -25,7 +25,9 @@ type Anime struct {
 }
 
 type ServiceAnime interface {
-	CreateAnime(reqAnime *Anime) (*Anime, error)
+	// CreateAnime records the anime's create event in the outbox along with
+	// the id of the request that created it.
+	CreateAnime(reqAnime *Anime, requestId string) (*Anime, error)
 	GetAnimeById(reqAnime *Anime) (*Anime, error)
 	GetAnimeByName(reqAnime *Anime) (*Anime, error)
 	UpdateAnime(reqAnime *Anime) (*Anime, error)
@@ -49,7 +51,7 @@ func NewServiceAnime(db ServiceDb) ServiceAnime {
 	return serviceAnimeInstance
 }
 
-func (s *serviceAnime) CreateAnime(reqAnime *Anime) (*Anime, error) {
+func (s *serviceAnime) CreateAnime(reqAnime *Anime, requestId string) (*Anime, error) {
 	tx, err := s.db.Conn().Begin()
 	if err != nil {
 		return nil, err
@@ -68,7 +70,7 @@ func (s *serviceAnime) CreateAnime(reqAnime *Anime) (*Anime, error) {
 		return nil, err
 	}
 
-	if err := InsertAnimeCreateEvent(tx, result); err != nil {
+	if err := InsertAnimeCreateEvent(tx, result, requestId); err != nil {
 		return nil, err
 	}
 
@@ -315,10 +317,10 @@ func DeleteAnimeById(tx *sql.Tx, reqAnime *Anime) error {
 	return nil
 }
 
-func InsertAnimeCreateEvent(tx *sql.Tx, reqAnime *Anime) error {
+func InsertAnimeCreateEvent(tx *sql.Tx, reqAnime *Anime, requestId string) error {
 	query := `
-	INSERT INTO event_anime (id, anime_id, name, episodes, event_type, status)
-	VALUES ($1, $2, $3, $4, $5, $6)
+	INSERT INTO outbox (id, anime_id, name, episodes, event_type, status, request_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`
 
 	queryResult, err := tx.Exec(
@@ -329,6 +331,7 @@ func InsertAnimeCreateEvent(tx *sql.Tx, reqAnime *Anime) error {
 		reqAnime.Episodes,
 		EVENT_TYPE_ANIME_CREATE,
 		EVENT_STATUS_INCOMPLETE,
+		requestId,
 	)
 	if err != nil {
 		return err
@@ -20,7 +20,7 @@ const (
 const (
 	EVENT_CREATE = "create"
 	EVENT_UPDATE = "update"
-	EVENT_DELETE = "create"
+	EVENT_DELETE = "delete"
 )
 
 type Event struct {
@@ -32,6 +32,7 @@ type Event struct {
 	Episodes  int         `json:"episodes"`
 	EventType EventType   `json:"event_type"`
 	Status    EventStatus `json:"status"`
+	RequestId string      `json:"request_id"`
 }
 
 type ServiceOutbox interface {
@@ -150,7 +151,7 @@ func SelectIncomplete(tx *sql.Tx) (*Event, error) {
 	FROM next_incomplete
 	WHERE o.id = next_incomplete.id
 	AND o.status = 'incomplete'
-	RETURNING o.id, o.created_at, o.updated_at, o.anime_id, o.name, o.episodes, o.event_type, o.status
+	RETURNING o.id, o.created_at, o.updated_at, o.anime_id, o.name, o.episodes, o.event_type, o.status, o.request_id
 	`
 
 	result := &Event{}
@@ -167,6 +168,7 @@ func SelectIncomplete(tx *sql.Tx) (*Event, error) {
 		&result.Episodes,
 		&result.EventType,
 		&result.Status,
+		&result.RequestId,
 	); err != nil {
 		return nil, err
 	}
@@ -181,7 +183,7 @@ func UpdateEventStatusIncomplete(tx *sql.Tx, reqEvent *Event) (*Event, error) {
 		updated_at = NOW(),
 		status = $2
 	WHERE id = $1
-	RETURNING id, created_at, updated_at, anime_id, name, episodes, event_type, status
+	RETURNING id, created_at, updated_at, anime_id, name, episodes, event_type, status, request_id
 	`
 
 	result := &Event{}
@@ -199,6 +201,7 @@ func UpdateEventStatusIncomplete(tx *sql.Tx, reqEvent *Event) (*Event, error) {
 		&result.Episodes,
 		&result.EventType,
 		&result.Status,
+		&result.RequestId,
 	); err != nil {
 		return nil, err
 	}
@@ -213,7 +216,7 @@ func UpdateEventStatusCompleted(tx *sql.Tx, reqEvent *Event) (*Event, error) {
 		updated_at = NOW(),
 		status = $2
 	WHERE id = $1
-	RETURNING id, created_at, updated_at, anime_id, name, episodes, event_type, status
+	RETURNING id, created_at, updated_at, anime_id, name, episodes, event_type, status, request_id
 	`
 
 	result := &Event{}
@@ -231,6 +234,7 @@ func UpdateEventStatusCompleted(tx *sql.Tx, reqEvent *Event) (*Event, error) {
 		&result.Episodes,
 		&result.EventType,
 		&result.Status,
+		&result.RequestId,
 	); err != nil {
 		return nil, err
 	}
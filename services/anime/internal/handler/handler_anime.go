@@ -2,10 +2,10 @@ package handler
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"strings"
 
+	"github.com/JustinLi007/whatdoing/libs/go/requestid"
 	"github.com/JustinLi007/whatdoing/libs/go/util"
 	"github.com/JustinLi007/whatdoing/services/anime/internal/database"
 
@@ -46,7 +46,7 @@ func (h *handlerAnime) CreateAnime(w http.ResponseWriter, r *http.Request) {
 
 	var req CreateAnimeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -57,7 +57,7 @@ func (h *handlerAnime) CreateAnime(w http.ResponseWriter, r *http.Request) {
 	episodes := req.Episodes
 
 	if name == "" {
-		log.Printf("error: %v", "missing name")
+		requestid.Printf(r.Context(), "error: %v", "missing name")
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -65,7 +65,7 @@ func (h *handlerAnime) CreateAnime(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if episodes <= 0 {
-		log.Printf("error: %v", "episodes <= 0")
+		requestid.Printf(r.Context(), "error: %v", "episodes <= 0")
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -76,9 +76,9 @@ func (h *handlerAnime) CreateAnime(w http.ResponseWriter, r *http.Request) {
 		Name:     name,
 		Episodes: episodes,
 	}
-	dbAnime, err := h.animeService.CreateAnime(reqAnime)
+	dbAnime, err := h.animeService.CreateAnime(reqAnime, requestid.FromContext(r.Context()))
 	if err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusInternalServerError, util.Envelope{
 			"message": "internal server error",
 		})
@@ -97,7 +97,7 @@ func (h *handlerAnime) GetAnime(w http.ResponseWriter, r *http.Request) {
 
 	var req GetAnimeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -105,7 +105,7 @@ func (h *handlerAnime) GetAnime(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := uuid.Validate(req.Id); err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -114,7 +114,7 @@ func (h *handlerAnime) GetAnime(w http.ResponseWriter, r *http.Request) {
 
 	id, err := uuid.Parse(req.Id)
 	if err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -126,7 +126,7 @@ func (h *handlerAnime) GetAnime(w http.ResponseWriter, r *http.Request) {
 	}
 	dbAnime, err := h.animeService.GetAnimeById(reqAnime)
 	if err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusInternalServerError, util.Envelope{
 			"message": "internal server error",
 		})
@@ -147,7 +147,7 @@ func (h *handlerAnime) UpdateAnime(w http.ResponseWriter, r *http.Request) {
 
 	var req UpdateAnimeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -155,7 +155,7 @@ func (h *handlerAnime) UpdateAnime(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := uuid.Validate(req.Id); err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -164,7 +164,7 @@ func (h *handlerAnime) UpdateAnime(w http.ResponseWriter, r *http.Request) {
 
 	id, err := uuid.Parse(req.Id)
 	if err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -175,7 +175,7 @@ func (h *handlerAnime) UpdateAnime(w http.ResponseWriter, r *http.Request) {
 	episodes := req.Episodes
 
 	if name == "" {
-		log.Printf("error: %v", "missing name")
+		requestid.Printf(r.Context(), "error: %v", "missing name")
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -183,7 +183,7 @@ func (h *handlerAnime) UpdateAnime(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if episodes <= 0 {
-		log.Printf("error: %v", "episodes <= 0")
+		requestid.Printf(r.Context(), "error: %v", "episodes <= 0")
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -197,7 +197,7 @@ func (h *handlerAnime) UpdateAnime(w http.ResponseWriter, r *http.Request) {
 	}
 	dbAnime, err := h.animeService.UpdateAnime(reqAnime)
 	if err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusInternalServerError, util.Envelope{
 			"message": "internal server error",
 		})
@@ -216,7 +216,7 @@ func (h *handlerAnime) DeleteAnime(w http.ResponseWriter, r *http.Request) {
 
 	var req DeleteAnimeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -224,7 +224,7 @@ func (h *handlerAnime) DeleteAnime(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := uuid.Validate(req.Id); err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -233,7 +233,7 @@ func (h *handlerAnime) DeleteAnime(w http.ResponseWriter, r *http.Request) {
 
 	id, err := uuid.Parse(req.Id)
 	if err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusBadRequest, util.Envelope{
 			"message": "bad request",
 		})
@@ -244,7 +244,7 @@ func (h *handlerAnime) DeleteAnime(w http.ResponseWriter, r *http.Request) {
 		Id: id,
 	}
 	if err := h.animeService.DeleteAnimeById(reqAnime); err != nil {
-		log.Printf("error: %v", err)
+		requestid.Printf(r.Context(), "error: %v", err)
 		util.WriteJson(w, http.StatusInternalServerError, util.Envelope{
 			"message": "internal server error",
 		})
@@ -2,12 +2,16 @@ package pubsub
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/JustinLi007/whatdoing/libs/go/config"
 	"github.com/JustinLi007/whatdoing/libs/go/pubsub"
+	"github.com/JustinLi007/whatdoing/libs/go/requestid"
 	"github.com/JustinLi007/whatdoing/libs/go/util"
 	"github.com/JustinLi007/whatdoing/services/anime/internal/database"
 
@@ -79,12 +83,7 @@ func (p *publisher) start(ctx context.Context, wg *sync.WaitGroup) {
 	for {
 		select {
 		case <-timer.C:
-			pubsub.PublishJSON(
-				p.ch,
-				"whatdoing",
-				"anime.test",
-				[]byte("test"),
-			)
+			p.publishOutbox()
 			timer.Reset(p.interval)
 		case <-ctx.Done():
 			return
@@ -92,6 +91,40 @@ func (p *publisher) start(ctx context.Context, wg *sync.WaitGroup) {
 	}
 }
 
+// publishOutbox drains the outbox. Events that fail to publish are put back to
+// be retried on the next tick.
+func (p *publisher) publishOutbox() {
+	for {
+		event, err := p.outboxService.GetIncomplete()
+		if errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if err != nil {
+			log.Printf("error: %v", err)
+			return
+		}
+
+		if err := pubsub.PublishJSON(
+			p.ch,
+			"whatdoing",
+			fmt.Sprintf("anime.%s", event.EventType),
+			event,
+		); err != nil {
+			requestid.Logf(event.RequestId, "error: publish event %v: %v", event.Id, err)
+			if _, err := p.outboxService.MarkIncomplete(event); err != nil {
+				log.Printf("error: %v", err)
+			}
+			return
+		}
+
+		if _, err := p.outboxService.MarkCompleted(event); err != nil {
+			log.Printf("error: %v", err)
+			return
+		}
+		requestid.Logf(event.RequestId, "published event %v anime.%s", event.Id, event.EventType)
+	}
+}
+
 func (p *publisher) connect() {
 	conn, err := amqp.Dial(p.url)
 	util.RequireNoError(err, "error: publisher failed to establish a connection")
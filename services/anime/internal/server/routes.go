@@ -3,6 +3,7 @@ package server
 import (
 	"net/http"
 
+	"github.com/JustinLi007/whatdoing/libs/go/requestid"
 	"github.com/JustinLi007/whatdoing/libs/go/util"
 
 	"github.com/go-chi/chi/v5"
@@ -10,6 +11,7 @@ import (
 
 func (s *Server) RegisterRoutes() *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(requestid.Middleware)
 
 	r.Group(func(r chi.Router) {
 		r.Post("/anime", s.animeHandler.CreateAnime)
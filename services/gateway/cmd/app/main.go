@@ -20,15 +20,37 @@ func main() {
 
 	c := config.NewBuilder().
 		Env("SERVER_PORT").
+		Env("METRICS_PORT").
 		Env("JWK_URL").
 		Env("JWT_ISSUER").
 		Env("JWT_AUDIENCE").
+		Env("CORS_ALLOWED_ORIGINS").
+		Env("CORS_ALLOWED_METHODS").
+		Env("CORS_ALLOWED_HEADERS").
+		Env("CORS_MAX_AGE").
+		Env("CORS_ALLOW_CREDENTIALS").
+		Env("ROUTES_FILE").
+		Env("ROUTES_RELOAD_INTERVAL").
+		Env("HEALTH_CHECK_INTERVAL").
+		Env("ACCESS_LOG_SAMPLE_RATE").
+		Env("ACCESS_LOG_REDACT_HEADERS").
+		Env("TRUSTED_PROXIES").
+		Env("REVOCATION_URL").
+		Env("REVOCATION_POLL_INTERVAL").
+		Env("AMQP_URL").
 		Build()
 	c.Parse()
 
-	server := server.NewServer(ctx, c)
+	server, metricsServer := server.NewServer(ctx, c)
 
-	go gracefullShutdown(server, done, cancel)
+	go gracefullShutdown(done, cancel, server, metricsServer)
+
+	go func() {
+		log.Printf("metrics listening on %v", metricsServer.Addr)
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("error: %v", err)
+		}
+	}()
 
 	log.Printf("listening on %v", server.Addr)
 	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -39,7 +61,7 @@ func main() {
 	log.Println("Graceful shutdown complete.")
 }
 
-func gracefullShutdown(server *http.Server, done chan bool, ctxCancel context.CancelFunc) {
+func gracefullShutdown(done chan bool, ctxCancel context.CancelFunc, servers ...*http.Server) {
 	signalCtx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 	<-signalCtx.Done()
@@ -51,8 +73,10 @@ func gracefullShutdown(server *http.Server, done chan bool, ctxCancel context.Ca
 
 	timeoutCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
-	if err := server.Shutdown(timeoutCtx); err != nil {
-		log.Printf("Server forced to shutdown with error: %v", err)
+	for _, server := range servers {
+		if err := server.Shutdown(timeoutCtx); err != nil {
+			log.Printf("Server forced to shutdown with error: %v", err)
+		}
 	}
 
 	log.Println("Server exiting")
@@ -0,0 +1,140 @@
+package accesslog
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/libs/go/requestid"
+)
+
+const REDACTED = "[redacted]"
+
+// DefaultRedactedHeaders are always redacted, whatever else is configured.
+var DefaultRedactedHeaders = []string{"Authorization", "Cookie"}
+
+type ctxKey int
+
+const (
+	entryCtxKey ctxKey = iota
+)
+
+// Entry holds what later handlers learn about a request, e.g. the matched
+// prefix and the chosen upstream, for the access log line.
+type Entry struct {
+	Prefix   string
+	Upstream string
+	UserId   string
+}
+
+// Logger writes one JSON line per request. Requests are sampled at
+// sampleRate, except server errors which are always logged.
+type Logger struct {
+	logger     *slog.Logger
+	sampleRate float64
+	redacted   map[string]bool
+	sample     func() float64
+}
+
+// NewLogger redacts redactedHeaders on top of DefaultRedactedHeaders.
+func NewLogger(w io.Writer, sampleRate float64, redactedHeaders []string) *Logger {
+	redacted := make(map[string]bool)
+	for _, h := range DefaultRedactedHeaders {
+		redacted[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, h := range redactedHeaders {
+		if h = strings.TrimSpace(h); h != "" {
+			redacted[http.CanonicalHeaderKey(h)] = true
+		}
+	}
+
+	return &Logger{
+		logger:     slog.New(slog.NewJSONHandler(w, nil)),
+		sampleRate: sampleRate,
+		redacted:   redacted,
+		sample:     rand.Float64,
+	}
+}
+
+func (l *Logger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		entry := &Entry{}
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), entryCtxKey, entry)))
+
+		if rw.status < 500 && l.sample() >= l.sampleRate {
+			return
+		}
+
+		l.logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("request_id", requestid.FromContext(r.Context())),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("prefix", entry.Prefix),
+			slog.String("upstream", entry.Upstream),
+			slog.Int("status", rw.status),
+			slog.Int64("bytes", rw.bytes),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("user_id", entry.UserId),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.Any("headers", l.headers(r.Header)),
+		)
+	})
+}
+
+func (l *Logger) headers(header http.Header) map[string]string {
+	result := make(map[string]string, len(header))
+	for k, v := range header {
+		if l.redacted[k] {
+			result[k] = REDACTED
+			continue
+		}
+		result[k] = strings.Join(v, ", ")
+	}
+	return result
+}
+
+// FromContext returns the request's entry, or a throwaway one when the
+// request is not being logged, so callers never need to check.
+func FromContext(ctx context.Context) *Entry {
+	entry, ok := ctx.Value(entryCtxKey).(*Entry)
+	if !ok {
+		return &Entry{}
+	}
+	return entry
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	if !rw.wroteHeader {
+		rw.status = statusCode
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. for
+// the reverse proxy to flush streamed responses.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,68 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerLogsEntry(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(&buf, 1, []string{"x-api-key"})
+
+	handler := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := FromContext(r.Context())
+		entry.Prefix = "anime"
+		entry.Upstream = "http://anime-service"
+		entry.UserId = "user-1"
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/anime?secret=1", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	r.AddCookie(&http.Cookie{Name: "jwt", Value: "token"})
+	r.Header.Set("X-Api-Key", "token")
+	r.Header.Set("Accept", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "POST", line["method"])
+	assert.Equal(t, "/anime", line["path"])
+	assert.Equal(t, "anime", line["prefix"])
+	assert.Equal(t, "http://anime-service", line["upstream"])
+	assert.Equal(t, "user-1", line["user_id"])
+	assert.Equal(t, float64(http.StatusCreated), line["status"])
+	assert.Equal(t, float64(5), line["bytes"])
+
+	headers := line["headers"].(map[string]any)
+	assert.Equal(t, REDACTED, headers["Authorization"])
+	assert.Equal(t, REDACTED, headers["Cookie"])
+	assert.Equal(t, REDACTED, headers["X-Api-Key"])
+	assert.Equal(t, "application/json", headers["Accept"])
+	assert.NotContains(t, buf.String(), "token")
+}
+
+func TestHandlerSampling(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(&buf, 0.5, nil)
+	l.sample = func() float64 { return 0.9 }
+
+	status := http.StatusOK
+	handler := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, buf.String())
+
+	// Server errors are logged regardless of sampling.
+	status = http.StatusBadGateway
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NotEmpty(t, buf.String())
+}
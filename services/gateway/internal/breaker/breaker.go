@@ -0,0 +1,170 @@
+package breaker
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	STATE_CLOSED State = iota
+	STATE_OPEN
+	STATE_HALF_OPEN
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Breaker stops traffic to a failing service. It opens after threshold
+// consecutive failures, rejects requests until openTimeout has passed, then
+// lets halfOpenRequests probes through. The breaker closes once all probes
+// succeed and opens again on the first failed probe.
+type Breaker struct {
+	mtx              sync.Mutex
+	name             string
+	threshold        int
+	openTimeout      time.Duration
+	halfOpenRequests int
+
+	state     State
+	failures  int
+	probes    int
+	successes int
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+type Status struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at,omitzero"`
+}
+
+func NewBreaker(name string, threshold int, openTimeout time.Duration, halfOpenRequests int) *Breaker {
+	return &Breaker{
+		mtx:              sync.Mutex{},
+		name:             name,
+		threshold:        threshold,
+		openTimeout:      openTimeout,
+		halfOpenRequests: halfOpenRequests,
+		state:            STATE_CLOSED,
+		now:              time.Now,
+	}
+}
+
+// Allow reports whether a request may go through. Every allowed request must
+// be followed by exactly one call to Success, Failure or Cancel.
+func (b *Breaker) Allow() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.state == STATE_OPEN {
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return ErrOpen
+		}
+		b.setState(STATE_HALF_OPEN)
+	}
+
+	if b.state == STATE_HALF_OPEN {
+		if b.probes >= b.halfOpenRequests {
+			return ErrOpen
+		}
+		b.probes++
+	}
+
+	return nil
+}
+
+func (b *Breaker) Success() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	switch b.state {
+	case STATE_CLOSED:
+		b.failures = 0
+	case STATE_HALF_OPEN:
+		b.successes++
+		if b.successes >= b.halfOpenRequests {
+			b.setState(STATE_CLOSED)
+		}
+	}
+}
+
+func (b *Breaker) Failure() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	switch b.state {
+	case STATE_CLOSED:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.setState(STATE_OPEN)
+		}
+	case STATE_HALF_OPEN:
+		b.setState(STATE_OPEN)
+	}
+}
+
+// Cancel gives back a probe slot for a request that ended without telling
+// anything about the service, e.g. one cancelled by the client.
+func (b *Breaker) Cancel() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.state == STATE_HALF_OPEN && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *Breaker) State() State {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) Status() Status {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	status := Status{
+		State:    b.state.String(),
+		Failures: b.failures,
+	}
+	if b.state != STATE_CLOSED {
+		status.OpenedAt = b.openedAt.UTC()
+	}
+	return status
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	log.Printf("circuit breaker %v: %v -> %v", b.name, b.state, state)
+
+	b.state = state
+	b.probes = 0
+	b.successes = 0
+	switch state {
+	case STATE_CLOSED:
+		b.failures = 0
+	case STATE_OPEN:
+		b.openedAt = b.now()
+	}
+}
+
+func (s State) String() string {
+	switch s {
+	case STATE_CLOSED:
+		return "closed"
+	case STATE_OPEN:
+		return "open"
+	case STATE_HALF_OPEN:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
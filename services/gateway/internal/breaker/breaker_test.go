@@ -0,0 +1,64 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := NewBreaker("test", 2, time.Second, 1)
+
+	assert.NoError(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, STATE_CLOSED, b.State())
+
+	// A success resets the consecutive failure count.
+	b.Success()
+	b.Failure()
+	assert.Equal(t, STATE_CLOSED, b.State())
+
+	b.Failure()
+	assert.Equal(t, STATE_OPEN, b.State())
+	assert.ErrorIs(t, b.Allow(), ErrOpen)
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	b := NewBreaker("test", 1, time.Second, 2)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	assert.ErrorIs(t, b.Allow(), ErrOpen)
+
+	now = now.Add(time.Second)
+	assert.NoError(t, b.Allow())
+	assert.Equal(t, STATE_HALF_OPEN, b.State())
+	assert.NoError(t, b.Allow())
+	assert.ErrorIs(t, b.Allow(), ErrOpen)
+
+	// A cancelled probe frees its slot.
+	b.Cancel()
+	assert.NoError(t, b.Allow())
+
+	b.Success()
+	assert.Equal(t, STATE_HALF_OPEN, b.State())
+	b.Success()
+	assert.Equal(t, STATE_CLOSED, b.State())
+}
+
+func TestBreakerReopensOnFailedProbe(t *testing.T) {
+	now := time.Now()
+	b := NewBreaker("test", 1, time.Second, 1)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	now = now.Add(time.Second)
+	assert.NoError(t, b.Allow())
+
+	b.Failure()
+	assert.Equal(t, STATE_OPEN, b.State())
+	assert.Equal(t, now.UTC(), b.Status().OpenedAt)
+	assert.ErrorIs(t, b.Allow(), ErrOpen)
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/accesslog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Requests that matched no route are labelled with this prefix.
+const UNMATCHED_PREFIX = "unmatched"
+
+type Metrics interface {
+	// Handler serves the metrics in the Prometheus text format.
+	Handler() http.Handler
+	// Middleware records proxied requests. It reads the matched prefix and
+	// upstream from the access log entry, so it must run inside AccessLog.
+	Middleware(next http.Handler) http.Handler
+	ObserveJwt(outcome string)
+}
+
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	jwtVerification *prometheus.CounterVec
+}
+
+var metricsInstance *metrics
+
+func NewMetrics() Metrics {
+	if metricsInstance != nil {
+		return metricsInstance
+	}
+
+	labels := []string{"prefix", "status_class", "upstream"}
+
+	newMetrics := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_requests_total",
+			Help: "Requests handled by the gateway per route.",
+		}, labels),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gateway_request_duration_seconds",
+			Help:    "Latency of requests handled by the gateway per route.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+		jwtVerification: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_jwt_verifications_total",
+			Help: "JWT verification outcomes on non public routes.",
+		}, []string{"outcome"}),
+	}
+
+	newMetrics.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newMetrics.requests,
+		newMetrics.requestDuration,
+		newMetrics.jwtVerification,
+	)
+	metricsInstance = newMetrics
+
+	return metricsInstance
+}
+
+func (m *metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		entry := accesslog.FromContext(r.Context())
+		prefix := entry.Prefix
+		if prefix == "" {
+			prefix = UNMATCHED_PREFIX
+		}
+
+		labels := prometheus.Labels{
+			"prefix":       prefix,
+			"status_class": fmt.Sprintf("%dxx", rw.status/100),
+			"upstream":     entry.Upstream,
+		}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+func (m *metrics) ObserveJwt(outcome string) {
+	m.jwtVerification.WithLabelValues(outcome).Inc()
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.status = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
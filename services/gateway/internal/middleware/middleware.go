@@ -1,103 +1,312 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 
+	"github.com/JustinLi007/whatdoing/libs/go/cors"
 	"github.com/JustinLi007/whatdoing/libs/go/util"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/accesslog"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/metrics"
 	"github.com/JustinLi007/whatdoing/services/gateway/internal/service"
 	"github.com/JustinLi007/whatdoing/services/gateway/internal/verifier"
 )
 
 type Middleware interface {
+	AccessLog(next http.Handler) http.Handler
 	Cors(next http.Handler) http.Handler
+	// ResolveEndpoint must run before the handlers below, which read the
+	// endpoint it stores in the request context.
+	ResolveEndpoint(next http.Handler) http.Handler
 	VerifyJwt(next http.Handler) http.Handler
+	RateLimitIp(next http.Handler) http.Handler
+	RateLimitUser(next http.Handler) http.Handler
 }
 
 type middleware struct {
 	verifier   verifier.Verifier
 	serviceMap service.ServiceMap
+	corsPolicy *cors.Policy
+	accessLog  *accesslog.Logger
+	metrics    metrics.Metrics
+	// trustedProxies may set X-Forwarded-For, the client address of requests
+	// from anyone else is the connection's address.
+	trustedProxies []netip.Prefix
 }
 
 var middlewareInstance *middleware
 
-var allowedOrigins = map[string]bool{
-	"http://localhost:5173": true,
-}
+var (
+	ErrNoToken          = errors.New("no token")
+	ErrMalformedToken   = errors.New("malformed authorization header")
+	ErrConflictingToken = errors.New("authorization header and jwt cookie hold different tokens")
+)
 
-func NewMiddleware(verifier verifier.Verifier, serviceMap service.ServiceMap) Middleware {
+func NewMiddleware(verifier verifier.Verifier, serviceMap service.ServiceMap, corsPolicy *cors.Policy, accessLog *accesslog.Logger, metrics metrics.Metrics, trustedProxies []netip.Prefix) Middleware {
 	if middlewareInstance != nil {
 		return middlewareInstance
 	}
 	newMiddleware := &middleware{
-		verifier:   verifier,
-		serviceMap: serviceMap,
+		verifier:       verifier,
+		serviceMap:     serviceMap,
+		corsPolicy:     corsPolicy,
+		accessLog:      accessLog,
+		metrics:        metrics,
+		trustedProxies: trustedProxies,
 	}
 	middlewareInstance = newMiddleware
 	return middlewareInstance
 }
 
+func (m *middleware) AccessLog(next http.Handler) http.Handler {
+	return m.accessLog.Handler(next)
+}
+
 func (m *middleware) Cors(next http.Handler) http.Handler {
+	return m.corsPolicy.Handler(next)
+}
+
+// ResolveEndpoint looks up the endpoint of the request's prefix once, answering
+// 404 for unknown prefixes.
+func (m *middleware) ResolveEndpoint(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		if allowedOrigins[origin] {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
+		prefix, _, ok := util.ParseRequestUrl(r)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
+		endpoint, err := m.serviceMap.GetEndpoint(prefix)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		accesslog.FromContext(r.Context()).Prefix = endpoint.Prefix
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(service.WithEndpoint(r.Context(), endpoint)))
 	})
 }
 
 func (m *middleware) VerifyJwt(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		prefix, _, ok := util.ParseRequestUrl(r)
+		// Only the gateway may set these, also on public routes.
+		r.Header.Del("Whatdoing-User-Id")
+		r.Header.Del("Whatdoing-Scope")
+		r.Header.Del("Whatdoing-Role")
+
+		endpoint, ok := service.EndpointFromContext(r.Context())
 		if !ok {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		endpoint, err := m.serviceMap.GetEndpoint(prefix)
-		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			return
+		public := endpoint.Public
+		rule, hasRule := endpoint.MatchRule(r.Method, r.URL.Path)
+		if hasRule {
+			public = rule.Public
 		}
 
-		if endpoint.Public {
+		if public {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		jwtCookie, err := r.Cookie("jwt")
+		token, err := tokenFromRequest(r)
+		if errors.Is(err, ErrConflictingToken) {
+			m.metrics.ObserveJwt(verifier.OUTCOME_CONFLICT)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, ErrNoToken) {
+			m.metrics.ObserveJwt(verifier.OUTCOME_MISSING)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 		if err != nil {
+			m.metrics.ObserveJwt(verifier.OUTCOME_MALFORMED)
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		sub, scope, err := m.verifier.ValidateJwt(jwtCookie.Value)
+		claims, err := m.verifier.ValidateJwt(token)
 		if err != nil {
+			m.metrics.ObserveJwt(verifier.Outcome(err))
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		if !util.HasScope(endpoint.Scope, scope) {
+		if !endpoint.Authorize(r.Method, claims.Scope) || (hasRule && !rule.Scopes.Allows(claims.Scope)) {
+			m.metrics.ObserveJwt(verifier.OUTCOME_MISSING_SCOPE)
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
+		m.metrics.ObserveJwt(verifier.OUTCOME_OK)
 
-		r.Header.Del("Whatdoing-User-Id")
-		r.Header.Del("Whatdoing-Scope")
+		accesslog.FromContext(r.Context()).UserId = claims.Subject
 
-		r.Header.Set("Whatdoing-User-Id", sub)
-		r.Header.Set("Whatdoing-Scope", scope)
+		r.Header.Set("Whatdoing-User-Id", claims.Subject)
+		r.Header.Set("Whatdoing-Scope", claims.Scope)
+		if claims.Role != "" {
+			r.Header.Set("Whatdoing-Role", claims.Role)
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// tokenFromRequest reads the jwt from the Authorization bearer header, falling
+// back to the jwt cookie. Requests carrying both must carry the same token.
+func tokenFromRequest(r *http.Request) (string, error) {
+	var cookieToken string
+	if jwtCookie, err := r.Cookie("jwt"); err == nil {
+		cookieToken = jwtCookie.Value
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		if cookieToken == "" {
+			return "", ErrNoToken
+		}
+		return cookieToken, nil
+	}
+
+	scheme, headerToken, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", ErrMalformedToken
+	}
+	headerToken = strings.TrimSpace(headerToken)
+	if headerToken == "" {
+		return "", ErrMalformedToken
+	}
+
+	if cookieToken != "" && cookieToken != headerToken {
+		return "", ErrConflictingToken
+	}
+
+	return headerToken, nil
+}
+
+// RateLimitIp limits every request per client IP. It runs before VerifyJwt so
+// that requests with missing or invalid tokens are limited too.
+func (m *middleware) RateLimitIp(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.allow(w, r, "ip:"+clientIp(r, m.trustedProxies)) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimitUser additionally limits verified requests per user, so a user
+// spreading requests across addresses shares one bucket. It must run after
+// VerifyJwt, which sets the user id header.
+func (m *middleware) RateLimitUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Header.Get("Whatdoing-User-Id")
+		if userId != "" && !m.allow(w, r, "user:"+userId) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token for key from the limiter of the request's endpoint and
+// answers 429 when there is none left. Requests without a resolved endpoint
+// or to endpoints without a rate limit are always allowed.
+func (m *middleware) allow(w http.ResponseWriter, r *http.Request, key string) bool {
+	endpoint, ok := service.EndpointFromContext(r.Context())
+	if !ok {
+		return true
+	}
+
+	limiter := endpoint.LimiterFor(r.Method, r.URL.Path)
+	if limiter == nil {
+		return true
+	}
+
+	allowed, retryAfter := limiter.Allow(key)
+	if !allowed {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+		util.WriteJson(w, http.StatusTooManyRequests, util.Envelope{
+			"message": "too many requests",
+		})
+		return false
+	}
+
+	return true
+}
+
+// ParseTrustedProxies reads a comma separated list of IPs and CIDRs.
+func ParseTrustedProxies(v string) ([]netip.Prefix, error) {
+	result := make([]netip.Prefix, 0)
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %v", entry, err)
+			}
+			result = append(result, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %v", entry, err)
+		}
+		result = append(result, prefix.Masked())
+	}
+	return result, nil
+}
+
+// clientIp is the connection's address unless it is a trusted proxy. Then
+// X-Forwarded-For is read from the right, skipping the entries appended by
+// trusted proxies, since anything further left was set by the client.
+func clientIp(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted(host, trustedProxies) {
+		return host
+	}
+
+	entries := make([]string, 0)
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		entries = append(entries, strings.Split(v, ",")...)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(entries[i])
+		if ip == "" {
+			continue
+		}
+		host = ip
+		if !trusted(ip, trustedProxies) {
+			break
+		}
+	}
+	return host
+}
+
+func trusted(ip string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/metrics"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenFromRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		cookie        string
+		token         string
+		err           error
+	}{
+		{name: "none", err: ErrNoToken},
+		{name: "cookie", cookie: "a", token: "a"},
+		{name: "header", authorization: "Bearer a", token: "a"},
+		{name: "scheme is case insensitive", authorization: "bearer a", token: "a"},
+		{name: "same token in both", authorization: "Bearer a", cookie: "a", token: "a"},
+		{name: "conflicting tokens", authorization: "Bearer a", cookie: "b", err: ErrConflictingToken},
+		{name: "wrong scheme", authorization: "Basic a", cookie: "a", err: ErrMalformedToken},
+		{name: "empty bearer", authorization: "Bearer ", err: ErrMalformedToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/anime", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "jwt", Value: tt.cookie})
+			}
+
+			token, err := tokenFromRequest(r)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.token, token)
+		})
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		ip         string
+	}{
+		{name: "no header", remoteAddr: "1.2.3.4:1000", ip: "1.2.3.4"},
+		{name: "untrusted peer ignores header", remoteAddr: "1.2.3.4:1000", xff: []string{"5.6.7.8"}, ip: "1.2.3.4"},
+		{name: "trusted peer", remoteAddr: "10.0.0.1:1000", xff: []string{"5.6.7.8"}, ip: "5.6.7.8"},
+		{name: "spoofed entries left of the client", remoteAddr: "10.0.0.1:1000", xff: []string{"9.9.9.9, 5.6.7.8"}, ip: "5.6.7.8"},
+		{name: "chain of trusted proxies", remoteAddr: "10.0.0.1:1000", xff: []string{"5.6.7.8, 192.168.1.1", "10.0.0.2"}, ip: "5.6.7.8"},
+		{name: "trusted peer without header", remoteAddr: "10.0.0.1:1000", ip: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/anime", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			assert.Equal(t, tt.ip, clientIp(r, trustedProxies))
+		})
+	}
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+}
+
+func TestRateLimitBeforeVerifyJwt(t *testing.T) {
+	serviceMap := service.NewServiceMap()
+	_, err := serviceMap.LoadRoutes([]service.Route{{
+		Prefix:    "anime",
+		Url:       "http://anime-service",
+		RateLimit: &service.RateLimit{Requests: 1, Per: time.Minute},
+	}})
+	require.NoError(t, err)
+
+	m := &middleware{serviceMap: serviceMap, metrics: metrics.NewMetrics()}
+	handler := m.ResolveEndpoint(m.RateLimitIp(m.VerifyJwt(m.RateLimitUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))))
+
+	// Requests without a token are rejected by VerifyJwt, but still use up
+	// the client's budget.
+	statuses := make([]int, 0)
+	for range 2 {
+		r := httptest.NewRequest(http.MethodGet, "/anime/1", nil)
+		r.RemoteAddr = "1.2.3.4:1000"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		statuses = append(statuses, rr.Code)
+	}
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusTooManyRequests}, statuses)
+
+	r := httptest.NewRequest(http.MethodGet, "/anime/1", nil)
+	r.RemoteAddr = "5.6.7.8:1000"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
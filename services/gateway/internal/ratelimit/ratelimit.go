@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is a set of token buckets, one per key. Each bucket holds up to
+// burst tokens and refills at requests per interval.
+type Limiter struct {
+	mtx       sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	idle      time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(requests int, per time.Duration, burst int) *Limiter {
+	if burst <= 0 {
+		burst = requests
+	}
+
+	return &Limiter{
+		mtx:     sync.Mutex{},
+		rate:    float64(requests) / per.Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		// A bucket idle this long is full again and can be forgotten.
+		idle: time.Duration(float64(burst)/(float64(requests)/per.Seconds())*float64(time.Second)) + time.Minute,
+		now:  time.Now,
+	}
+}
+
+// Allow takes a token from the key's bucket. When the bucket is empty it
+// reports how long until the next token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens: l.burst,
+			last:   now,
+		}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / l.rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if l.lastSweep.IsZero() {
+		l.lastSweep = now
+		return
+	}
+	if now.Sub(l.lastSweep) < l.idle {
+		return
+	}
+	l.lastSweep = now
+
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= l.idle {
+			delete(l.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterBurstThenRefill(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(2, time.Second, 2)
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+
+	ok, retryAfter := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Millisecond*500, retryAfter)
+
+	// Keys do not share buckets.
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	now = now.Add(time.Millisecond * 500)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+}
+
+func TestLimiterSweepsIdleBuckets(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(1, time.Second, 1)
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	assert.Len(t, l.buckets, 1)
+
+	now = now.Add(l.idle)
+	l.Allow("b")
+	assert.Len(t, l.buckets, 1)
+}
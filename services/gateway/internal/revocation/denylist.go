@@ -0,0 +1,115 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+// Denylist holds revoked jwts until the tokens expire on their own. Tokens are
+// revoked one by one by id, or all tokens of a user issued before a point in
+// time.
+type Denylist interface {
+	Add(jti string, expiry time.Time)
+	AddUser(userId string, notBefore, expiry time.Time)
+	Revoked(jti, userId string, issuedAt time.Time) bool
+	Len() int
+}
+
+type userEntry struct {
+	notBefore time.Time
+	expiry    time.Time
+}
+
+type denylist struct {
+	mtx     sync.RWMutex
+	entries map[string]time.Time
+	users   map[string]userEntry
+	now     func() time.Time
+}
+
+var denylistInstance *denylist
+
+func NewDenylist() Denylist {
+	if denylistInstance != nil {
+		return denylistInstance
+	}
+	denylistInstance = newDenylist(time.Now)
+	return denylistInstance
+}
+
+func newDenylist(now func() time.Time) *denylist {
+	return &denylist{
+		mtx:     sync.RWMutex{},
+		entries: make(map[string]time.Time),
+		users:   make(map[string]userEntry),
+		now:     now,
+	}
+}
+
+// Add ignores tokens that already expired, the verifier rejects them anyway.
+func (d *denylist) Add(jti string, expiry time.Time) {
+	if jti == "" || !d.now().Before(expiry) {
+		return
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.entries[jti] = expiry
+	d.prune()
+}
+
+// AddUser keeps the latest notBefore when a user is revoked more than once.
+func (d *denylist) AddUser(userId string, notBefore, expiry time.Time) {
+	if userId == "" || !d.now().Before(expiry) {
+		return
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if entry, ok := d.users[userId]; ok && entry.notBefore.After(notBefore) {
+		return
+	}
+	d.users[userId] = userEntry{
+		notBefore: notBefore,
+		expiry:    expiry,
+	}
+	d.prune()
+}
+
+// Revoked reports whether the token was revoked by id, or was issued to a
+// revoked user before the revocation.
+func (d *denylist) Revoked(jti, userId string, issuedAt time.Time) bool {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+	now := d.now()
+
+	if expiry, ok := d.entries[jti]; ok && now.Before(expiry) {
+		return true
+	}
+
+	// "iat" has second precision. Tokens issued within the second of the
+	// revocation are accepted so that refreshing right after it works.
+	entry, ok := d.users[userId]
+	return ok && now.Before(entry.expiry) && issuedAt.Before(entry.notBefore.Truncate(time.Second))
+}
+
+func (d *denylist) Len() int {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+	return len(d.entries) + len(d.users)
+}
+
+// prune drops expired entries, callers hold the write lock.
+func (d *denylist) prune() {
+	now := d.now()
+	for jti, expiry := range d.entries {
+		if !now.Before(expiry) {
+			delete(d.entries, jti)
+		}
+	}
+	for userId, entry := range d.users {
+		if !now.Before(entry.expiry) {
+			delete(d.users, userId)
+		}
+	}
+}
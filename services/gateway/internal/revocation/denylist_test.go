@@ -0,0 +1,69 @@
+package revocation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDenylist(t *testing.T) {
+	now := time.Now()
+	d := newDenylist(func() time.Time { return now })
+
+	d.Add("a", now.Add(time.Minute))
+	d.Add("expired", now.Add(-time.Minute))
+	assert.True(t, d.Revoked("a", "", now))
+	assert.False(t, d.Revoked("expired", "", now))
+	assert.False(t, d.Revoked("b", "", now))
+
+	now = now.Add(time.Minute * 2)
+	assert.False(t, d.Revoked("a", "", now))
+
+	d.Add("c", now.Add(time.Minute))
+	assert.Equal(t, 1, d.Len())
+}
+
+func TestDenylistUser(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 500, time.UTC)
+	d := newDenylist(func() time.Time { return now })
+
+	d.AddUser("u", now, now.Add(time.Hour))
+	assert.True(t, d.Revoked("", "u", now.Add(-time.Minute)))
+	// Tokens issued within the second of the revocation are still accepted.
+	assert.False(t, d.Revoked("", "u", now.Truncate(time.Second)))
+	assert.False(t, d.Revoked("", "u", now.Add(time.Minute)))
+	assert.False(t, d.Revoked("", "other", now.Add(-time.Minute)))
+
+	// An older revocation arriving late does not undo a newer one.
+	d.AddUser("u", now.Add(-time.Hour), now.Add(time.Hour))
+	assert.True(t, d.Revoked("", "u", now.Add(-time.Minute)))
+
+	now = now.Add(time.Hour * 2)
+	assert.False(t, d.Revoked("", "u", now.Add(-time.Hour*3)))
+}
+
+func TestPoll(t *testing.T) {
+	created := time.Now().UTC()
+	var gotSince string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSince = r.URL.Query().Get("since")
+		w.Write([]byte(`{"revoked_tokens":[{"jti":"a","created_at":"` + created.Format(time.RFC3339Nano) + `","expires_at":"` + created.Add(time.Hour).Format(time.RFC3339Nano) + `"}],` +
+			`"revoked_users":[{"user_id":"u","created_at":"` + created.Format(time.RFC3339Nano) + `","not_before":"` + created.Format(time.RFC3339Nano) + `","expires_at":"` + created.Add(time.Hour).Format(time.RFC3339Nano) + `"}]}`))
+	}))
+	defer srv.Close()
+
+	d := newDenylist(time.Now)
+	p := &poller{url: srv.URL, client: srv.Client(), denylist: d}
+
+	require.NoError(t, p.Poll(t.Context()))
+	assert.Empty(t, gotSince)
+	assert.True(t, d.Revoked("a", "", time.Now()))
+	assert.True(t, d.Revoked("", "u", created.Add(-time.Minute)))
+
+	require.NoError(t, p.Poll(t.Context()))
+	assert.Equal(t, created.Add(-POLL_OVERLAP).Format(time.RFC3339Nano), gotSince)
+}
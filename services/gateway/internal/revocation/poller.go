@@ -0,0 +1,139 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DEFAULT_POLL_INTERVAL = time.Second * 10
+	// POLL_OVERLAP re-requests a window before the newest revocation seen so
+	// rows committed out of order are not missed. Adding a jti twice is
+	// harmless.
+	POLL_OVERLAP = time.Minute
+)
+
+// Poller keeps the denylist in sync with the users service's revoked tokens.
+type Poller interface {
+	Start(ctx context.Context)
+	Poll(ctx context.Context) error
+}
+
+type poller struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	denylist Denylist
+	since    time.Time
+}
+
+type revokedToken struct {
+	Jti       string    `json:"jti"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type revokedUser struct {
+	UserId    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	NotBefore time.Time `json:"not_before"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var pollerInstance *poller
+
+func NewPoller(url string, interval time.Duration, denylist Denylist) Poller {
+	if pollerInstance != nil {
+		return pollerInstance
+	}
+
+	if interval <= 0 {
+		interval = DEFAULT_POLL_INTERVAL
+	}
+
+	newPoller := &poller{
+		url:      url,
+		interval: interval,
+		client: &http.Client{
+			Timeout: time.Second * 5,
+		},
+		denylist: denylist,
+	}
+	pollerInstance = newPoller
+
+	return pollerInstance
+}
+
+func (p *poller) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Poll(ctx); err != nil {
+			log.Printf("error: revocation poll: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *poller) Poll(ctx context.Context) error {
+	reqUrl, err := url.Parse(p.url)
+	if err != nil {
+		return err
+	}
+	if !p.since.IsZero() {
+		query := reqUrl.Query()
+		query.Set("since", p.since.Add(-POLL_OVERLAP).Format(time.RFC3339Nano))
+		reqUrl.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	var body struct {
+		RevokedTokens []revokedToken `json:"revoked_tokens"`
+		RevokedUsers  []revokedUser  `json:"revoked_users"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return err
+	}
+
+	since := p.since
+	for _, revoked := range body.RevokedTokens {
+		p.denylist.Add(revoked.Jti, revoked.ExpiresAt)
+		if revoked.CreatedAt.After(since) {
+			since = revoked.CreatedAt
+		}
+	}
+	for _, revoked := range body.RevokedUsers {
+		p.denylist.AddUser(revoked.UserId, revoked.NotBefore, revoked.ExpiresAt)
+		if revoked.CreatedAt.After(since) {
+			since = revoked.CreatedAt
+		}
+	}
+	p.since = since
+
+	return nil
+}
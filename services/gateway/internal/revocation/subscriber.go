@@ -0,0 +1,108 @@
+package revocation
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/libs/go/pubsub"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Subscriber applies the auth.revoked events published by the users service
+// to the denylist as they happen. Every gateway instance gets its own
+// transient queue, the poller catches up on events missed while disconnected.
+type Subscriber interface {
+	Start(ctx context.Context) error
+}
+
+type subscriber struct {
+	url      string
+	denylist Denylist
+}
+
+// RevokedEvent mirrors the users service's outbox event for auth.revoked.
+type RevokedEvent struct {
+	Id        string `json:"id"`
+	EventType string `json:"event_type"`
+	Payload   struct {
+		Jti       string    `json:"jti"`
+		UserId    string    `json:"user_id"`
+		NotBefore time.Time `json:"not_before"`
+		ExpiresAt time.Time `json:"expires_at"`
+	} `json:"payload"`
+}
+
+var subscriberInstance *subscriber
+
+func NewSubscriber(url string, denylist Denylist) Subscriber {
+	if subscriberInstance != nil {
+		return subscriberInstance
+	}
+	newSubscriber := &subscriber{
+		url:      url,
+		denylist: denylist,
+	}
+	subscriberInstance = newSubscriber
+	return subscriberInstance
+}
+
+func (s *subscriber) Start(ctx context.Context) error {
+	conn, err := amqp.Dial(s.url)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	if err := pubsub.ExchangeDeclare(
+		ch,
+		"whatdoing",
+		"topic",
+		pubsub.EXCHANGE_TYPE_DURABLE,
+		nil,
+	); err != nil {
+		conn.Close()
+		return err
+	}
+
+	if err := pubsub.SubscribeJSON(
+		ch,
+		"whatdoing",
+		"",
+		"auth.revoked",
+		pubsub.QUEUE_TYPE_TRANSIENT,
+		nil,
+		handlerRevokedEvent(s.denylist),
+	); err != nil {
+		conn.Close()
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := conn.Close(); err != nil {
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func handlerRevokedEvent(denylist Denylist) pubsub.MessageHandler[*RevokedEvent] {
+	return func(e *RevokedEvent) pubsub.AckType {
+		if e.Payload.Jti != "" {
+			denylist.Add(e.Payload.Jti, e.Payload.ExpiresAt)
+		}
+		if e.Payload.UserId != "" {
+			denylist.AddUser(e.Payload.UserId, e.Payload.NotBefore, e.Payload.ExpiresAt)
+		}
+		log.Printf("received event %v %s", e.Id, e.EventType)
+		return pubsub.ACK
+	}
+}
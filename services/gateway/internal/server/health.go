@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/JustinLi007/whatdoing/libs/go/util"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/breaker"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/service"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/verifier"
+)
+
+type serviceStatus struct {
+	Prefix    string                   `json:"prefix"`
+	Healthy   bool                     `json:"healthy"`
+	Upstreams []service.UpstreamStatus `json:"upstreams"`
+	Breaker   breaker.Status           `json:"breaker"`
+}
+
+type readiness struct {
+	Ready    bool            `json:"ready"`
+	Services []serviceStatus `json:"services"`
+	Jwk      verifier.Status `json:"jwk"`
+}
+
+// Healthz reports liveness: it always answers 200 while the gateway is up,
+// along with the state of the stack behind it.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	status := s.readiness()
+
+	util.WriteJson(w, http.StatusOK, util.Envelope{
+		"message":  "gateway good",
+		"ready":    status.Ready,
+		"services": status.Services,
+		"jwk":      status.Jwk,
+	})
+}
+
+// Readyz reports readiness: 200 only when every service has a healthy
+// upstream and a breaker that is not open, and the JWK set used to verify
+// tokens is loaded.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	status := s.readiness()
+
+	statusCode := http.StatusOK
+	if !status.Ready {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	util.WriteJson(w, statusCode, util.Envelope{
+		"ready":    status.Ready,
+		"services": status.Services,
+		"jwk":      status.Jwk,
+	})
+}
+
+func (s *Server) readiness() readiness {
+	result := readiness{
+		Ready:    true,
+		Services: make([]serviceStatus, 0),
+		Jwk:      s.Verifier.Status(),
+	}
+
+	if !result.Jwk.Ready {
+		result.Ready = false
+	}
+
+	for _, endpoint := range s.ServiceMap.GetEndpoints() {
+		status := serviceStatus{
+			Prefix:    endpoint.Prefix,
+			Healthy:   endpoint.Pool.Healthy(),
+			Upstreams: make([]service.UpstreamStatus, 0),
+			Breaker:   endpoint.Breaker.Status(),
+		}
+		for _, upstream := range endpoint.Pool.Upstreams() {
+			status.Upstreams = append(status.Upstreams, upstream.Status())
+		}
+		if !status.Healthy || endpoint.Breaker.State() == breaker.STATE_OPEN {
+			result.Ready = false
+		}
+		result.Services = append(result.Services, status)
+	}
+
+	return result
+}
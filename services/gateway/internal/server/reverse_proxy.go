@@ -1,37 +1,192 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"net/http/httputil"
+	"strings"
 
+	"github.com/JustinLi007/whatdoing/libs/go/requestid"
 	"github.com/JustinLi007/whatdoing/libs/go/util"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/accesslog"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/service"
 )
 
+type ctxKey int
+
+const (
+	targetCtxKey ctxKey = iota
+)
+
+type outcome int
+
+const (
+	outcomeNone outcome = iota
+	outcomeSuccess
+	outcomeFailure
+)
+
+// target is the upstream chosen for a request, along with the pool it was
+// taken from so the proxy can report the outcome back to it.
+type target struct {
+	pool     *service.Pool
+	upstream *service.Upstream
+	// path is the request path as the upstream expects it.
+	path string
+	// outcome is left for Proxy to feed the endpoint's circuit breaker once
+	// the request is done.
+	outcome *outcome
+}
+
 func (s *Server) NewReverseProxy() *httputil.ReverseProxy {
 	rewriteFn := func(pr *httputil.ProxyRequest) {
-		prefix, pathValue, ok := util.ParseRequestUrl(pr.In)
+		target, ok := targetFromContext(pr.In.Context())
 		if !ok {
 			return
 		}
 
-		endpoint, err := s.ServiceMap.GetEndpoint(prefix)
-		if err != nil {
-			return
-		}
-		endpoint.Url = endpoint.Url.JoinPath("/")
-		if pathValue != "" {
-			endpoint.Url = endpoint.Url.JoinPath(pathValue)
-		}
+		// Joined in two steps, JoinPath keeps a relative path when the
+		// upstream url has none.
+		url := target.upstream.Url.JoinPath("/").JoinPath(target.path)
+		url.RawQuery = pr.In.URL.RawQuery
 
-		pr.Out.URL = endpoint.Url
+		pr.Out.URL = url
 		pr.Out.Host = pr.In.Host
 
 		pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
 		pr.SetXForwarded()
 	}
 
+	modifyResponseFn := func(resp *http.Response) error {
+		// The gateway already answers with the request id, the upstream's echo
+		// would duplicate it.
+		resp.Header.Del(requestid.HEADER)
+		// Same for cors, the gateway's policy applies to every route.
+		for header := range resp.Header {
+			if strings.HasPrefix(header, "Access-Control-") {
+				resp.Header.Del(header)
+			}
+		}
+
+		target, ok := targetFromContext(resp.Request.Context())
+		if !ok {
+			return nil
+		}
+
+		if resp.StatusCode >= 500 {
+			target.failure()
+		} else {
+			target.success()
+		}
+
+		return nil
+	}
+
+	errorHandlerFn := func(w http.ResponseWriter, r *http.Request, err error) {
+		requestid.Printf(r.Context(), "error: proxy %v: %v", r.URL.Path, err)
+
+		// A request cancelled by the client says nothing about the upstream.
+		if target, ok := targetFromContext(r.Context()); ok && !errors.Is(err, context.Canceled) {
+			target.failure()
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			util.WriteJson(w, http.StatusGatewayTimeout, util.Envelope{
+				"message": "upstream timed out",
+			})
+			return
+		}
+		util.WriteJson(w, http.StatusBadGateway, util.Envelope{
+			"message": "bad gateway",
+		})
+	}
+
 	rp := &httputil.ReverseProxy{
-		Rewrite: rewriteFn,
+		Rewrite:        rewriteFn,
+		ModifyResponse: modifyResponseFn,
+		ErrorHandler:   errorHandlerFn,
 	}
 
 	return rp
 }
+
+// Proxy picks an upstream from the resolved endpoint's pool and forwards the
+// request to it, bounded by the endpoint's timeout if it has one. Requests are
+// rejected without touching the upstream while the endpoint's circuit breaker
+// is open.
+func (s *Server) Proxy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint, ok := service.EndpointFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		prefix := endpoint.Prefix
+
+		upstreamPath := endpoint.UpstreamPath(r.URL.Path)
+		if service.InternalPath(upstreamPath) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err := endpoint.Breaker.Allow(); err != nil {
+			util.WriteJson(w, http.StatusServiceUnavailable, util.Envelope{
+				"message": fmt.Sprintf("service %q is unavailable", prefix),
+			})
+			return
+		}
+
+		upstream, err := endpoint.Pool.Acquire()
+		if err != nil {
+			endpoint.Breaker.Cancel()
+			requestid.Printf(r.Context(), "error: %v: %v", prefix, err)
+			util.WriteJson(w, http.StatusServiceUnavailable, util.Envelope{
+				"message": fmt.Sprintf("no healthy upstream for %q", prefix),
+			})
+			return
+		}
+		defer endpoint.Pool.Release(upstream)
+		accesslog.FromContext(r.Context()).Upstream = upstream.String()
+
+		result := outcomeNone
+		ctx := context.WithValue(r.Context(), targetCtxKey, target{
+			pool:     endpoint.Pool,
+			upstream: upstream,
+			path:     upstreamPath,
+			outcome:  &result,
+		})
+		if endpoint.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, endpoint.Timeout)
+			defer cancel()
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		switch result {
+		case outcomeSuccess:
+			endpoint.Breaker.Success()
+		case outcomeFailure:
+			endpoint.Breaker.Failure()
+		default:
+			endpoint.Breaker.Cancel()
+		}
+	})
+}
+
+func (t target) success() {
+	t.pool.ReportSuccess(t.upstream)
+	*t.outcome = outcomeSuccess
+}
+
+func (t target) failure() {
+	t.pool.ReportFailure(t.upstream)
+	*t.outcome = outcomeFailure
+}
+
+func targetFromContext(ctx context.Context) (target, bool) {
+	t, ok := ctx.Value(targetCtxKey).(target)
+	return t, ok
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/middleware"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyUpstreamPath(t *testing.T) {
+	var gotPath, gotQuery string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	keepPrefix := false
+	serviceMap := service.NewServiceMap()
+	_, err := serviceMap.LoadRoutes([]service.Route{
+		{Prefix: "auth", Url: upstream.URL, Public: true, StripPrefix: &keepPrefix},
+		{Prefix: "books", Url: upstream.URL, Public: true},
+	})
+	require.NoError(t, err)
+
+	s := &Server{ServiceMap: serviceMap}
+	m := middleware.NewMiddleware(nil, serviceMap, nil, nil, nil, nil)
+	handler := m.ResolveEndpoint(s.Proxy(s.NewReverseProxy()))
+
+	tests := []struct {
+		path  string
+		want  string
+		query string
+	}{
+		{path: "/auth/login", want: "/auth/login"},
+		{path: "/auth/me/password", want: "/auth/me/password"},
+		{path: "/books/1?page=2", want: "/1", query: "page=2"},
+		{path: "/books", want: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			gotPath, gotQuery = "", ""
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tt.path, nil))
+
+			assert.Equal(t, http.StatusNoContent, rr.Code)
+			assert.Equal(t, tt.want, gotPath)
+			assert.Equal(t, tt.query, gotQuery)
+		})
+	}
+}
+
+func TestProxyRefusesInternal(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer upstream.Close()
+
+	keepPrefix := false
+	serviceMap := service.NewServiceMap()
+	_, err := serviceMap.LoadRoutes([]service.Route{
+		{Prefix: "auth", Url: upstream.URL, Public: true, StripPrefix: &keepPrefix},
+		{Prefix: "books", Url: upstream.URL, Public: true},
+	})
+	require.NoError(t, err)
+
+	s := &Server{ServiceMap: serviceMap}
+	m := middleware.NewMiddleware(nil, serviceMap, nil, nil, nil, nil)
+	handler := m.ResolveEndpoint(s.Proxy(s.NewReverseProxy()))
+
+	for _, path := range []string{
+		"/books/internal/revoked-tokens",
+		"/books/internal",
+		"/books/./internal/revoked-tokens",
+		"/internal/revoked-tokens",
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code, path)
+	}
+	assert.False(t, called)
+}
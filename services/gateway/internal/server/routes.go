@@ -3,33 +3,38 @@ package server
 import (
 	"net/http"
 
-	"github.com/JustinLi007/whatdoing/libs/go/util"
+	"github.com/JustinLi007/whatdoing/libs/go/requestid"
+
 	"github.com/go-chi/chi/v5"
 )
 
 func (s *Server) RegisterRoutes() *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(requestid.Middleware)
+	r.Use(s.Middleware.AccessLog)
 	r.Use(s.Middleware.Cors)
 
 	r.Get("/healthz", s.Healthz)
+	r.Get("/readyz", s.Readyz)
 
-	r.Group(func(r chi.Router) {
-	})
+	r.Handle("/*", s.RegisterServices())
 
 	return r
 }
 
-func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
-	util.WriteJson(w, http.StatusOK, util.Envelope{
-		"message": "gateway good",
-	})
+// RegisterMetricsRoutes serves the metrics listener, which is not published.
+func (s *Server) RegisterMetricsRoutes() *chi.Mux {
+	r := chi.NewRouter()
+	r.Handle("/metrics", s.Metrics.Handler())
+	return r
 }
 
 func (s *Server) RegisterServices() http.Handler {
 	rp := s.NewReverseProxy()
-	s.ServiceMap.AddEndpoint("http://auth-service", "auth", "", true)
-	s.ServiceMap.AddEndpoint("http://anime-service", "anime", "", false)
-	s.ServiceMap.AddEndpoint("", "test", "ohfk", false)
-	handler := s.Middleware.Cors(s.Middleware.VerifyJwt(rp))
+	handler := s.Metrics.Middleware(
+		s.Middleware.ResolveEndpoint(s.Middleware.RateLimitIp(
+			s.Middleware.VerifyJwt(s.Middleware.RateLimitUser(s.Proxy(rp))),
+		)),
+	)
 	return handler
 }
@@ -5,55 +5,168 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/JustinLi007/whatdoing/libs/go/config"
+	"github.com/JustinLi007/whatdoing/libs/go/cors"
 	"github.com/JustinLi007/whatdoing/libs/go/util"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/accesslog"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/metrics"
 	"github.com/JustinLi007/whatdoing/services/gateway/internal/middleware"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/revocation"
 	"github.com/JustinLi007/whatdoing/services/gateway/internal/service"
 	"github.com/JustinLi007/whatdoing/services/gateway/internal/verifier"
 )
 
+// DEFAULT_METRICS_PORT serves /metrics apart from the proxied traffic so that
+// it is not published with the gateway's port.
+const DEFAULT_METRICS_PORT = 9090
+
 type Server struct {
-	Port       int
-	Issuer     string
-	Audience   string
-	JwkUrl     string
-	Middleware middleware.Middleware
-	ServiceMap service.ServiceMap
-	Verifier   verifier.Verifier
+	Port        int
+	MetricsPort int
+	Issuer      string
+	Audience    string
+	JwkUrl      string
+	RoutesFile  string
+	Reloader    service.Reloader
+	Health      service.HealthChecker
+	Metrics     metrics.Metrics
+	Middleware  middleware.Middleware
+	ServiceMap  service.ServiceMap
+	Verifier    verifier.Verifier
 }
 
-func NewServer(ctx context.Context, c *config.Config) *http.Server {
-	server := &Server{}
+// NewServer returns the gateway server and the metrics server.
+func NewServer(ctx context.Context, c *config.Config) (*http.Server, *http.Server) {
+	server := &Server{
+		MetricsPort: DEFAULT_METRICS_PORT,
+	}
 
 	port, err := strconv.Atoi(c.Get("SERVER_PORT"))
 	util.RequireNoError(err, "error: failed to parse port")
 	server.Port = port
+
+	if v := c.Get("METRICS_PORT"); v != "" {
+		server.MetricsPort, err = strconv.Atoi(v)
+		util.RequireNoError(err, "error: failed to parse metrics port")
+	}
+	if server.MetricsPort == server.Port {
+		log.Fatalf("error: %v", fmt.Errorf("METRICS_PORT must differ from SERVER_PORT"))
+	}
 	server.JwkUrl = c.Get("JWK_URL")
 	server.Issuer = c.Get("JWT_ISSUER")
 	server.Audience = c.Get("JWT_AUDIENCE")
+	server.RoutesFile = c.Get("ROUTES_FILE")
+
+	var reloadInterval time.Duration
+	if v := c.Get("ROUTES_RELOAD_INTERVAL"); v != "" {
+		reloadInterval, err = time.ParseDuration(v)
+		util.RequireNoError(err, "error: failed to parse routes reload interval")
+	}
+
+	var healthInterval time.Duration
+	if v := c.Get("HEALTH_CHECK_INTERVAL"); v != "" {
+		healthInterval, err = time.ParseDuration(v)
+		util.RequireNoError(err, "error: failed to parse health check interval")
+	}
+
+	corsPolicy, err := cors.NewPolicyFromConfig(c)
+	util.RequireNoError(err, "error: failed to load cors policy")
+
+	sampleRate := 1.0
+	if v := c.Get("ACCESS_LOG_SAMPLE_RATE"); v != "" {
+		sampleRate, err = strconv.ParseFloat(v, 64)
+		util.RequireNoError(err, "error: failed to parse access log sample rate")
+		if sampleRate < 0 || sampleRate > 1 {
+			log.Fatalf("error: access log sample rate %v must be between 0 and 1", sampleRate)
+		}
+	}
+
+	// Redacted on top of accesslog.DefaultRedactedHeaders.
+	var redactedHeaders []string
+	if v := c.Get("ACCESS_LOG_REDACT_HEADERS"); v != "" {
+		redactedHeaders = strings.Split(v, ",")
+	}
+
+	accessLog := accesslog.NewLogger(os.Stdout, sampleRate, redactedHeaders)
+
+	// Without trusted proxies the client ip is the connection's address and
+	// X-Forwarded-For is ignored.
+	trustedProxies, err := middleware.ParseTrustedProxies(c.Get("TRUSTED_PROXIES"))
+	util.RequireNoError(err, "error: failed to parse trusted proxies")
+
+	// revocation
+	var pollInterval time.Duration
+	if v := c.Get("REVOCATION_POLL_INTERVAL"); v != "" {
+		pollInterval, err = time.ParseDuration(v)
+		util.RequireNoError(err, "error: failed to parse revocation poll interval")
+	}
+
+	denylist := revocation.NewDenylist()
+	if revocationUrl := c.Get("REVOCATION_URL"); revocationUrl != "" {
+		poller := revocation.NewPoller(revocationUrl, pollInterval, denylist)
+		go poller.Start(ctx)
+	} else {
+		log.Printf("warning: REVOCATION_URL not set, revoked tokens are accepted until they expire")
+	}
+
+	if amqpUrl := c.Get("AMQP_URL"); amqpUrl != "" {
+		subscriber := revocation.NewSubscriber(amqpUrl, denylist)
+		if err := subscriber.Start(ctx); err != nil {
+			log.Printf("error: revocation subscriber: %v, relying on polling", err)
+		}
+	}
 
 	// verifier
-	verifier, err := verifier.NewVerifier(server.JwkUrl, server.Issuer, server.Audience)
+	verifier, err := verifier.NewVerifier(server.JwkUrl, server.Issuer, server.Audience, denylist)
 	if err != nil {
 		log.Fatalf("error: %v", err)
 	}
 
 	// services
+	routes, err := service.LoadRoutes(server.RoutesFile)
+	if err != nil {
+		log.Fatalf("error: failed to load routes from %q: %v", server.RoutesFile, err)
+	}
+
 	serviceMap := service.NewServiceMap()
+	if _, err := serviceMap.LoadRoutes(routes); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	reloader := service.NewReloader(server.RoutesFile, reloadInterval, serviceMap)
+	go reloader.Start(ctx)
+
+	healthChecker := service.NewHealthChecker(healthInterval, serviceMap)
+	go healthChecker.Start(ctx)
+
+	// metrics
+	metrics := metrics.NewMetrics()
 
 	// middleware
-	middleware := middleware.NewMiddleware(verifier, serviceMap)
+	middleware := middleware.NewMiddleware(verifier, serviceMap, corsPolicy, accessLog, metrics, trustedProxies)
 
 	// handlers
 
+	server.Metrics = metrics
 	server.Middleware = middleware
 	server.ServiceMap = serviceMap
+	server.Reloader = reloader
+	server.Health = healthChecker
 	server.Verifier = verifier
 
-	return &http.Server{
+	gatewayServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", server.Port),
-		Handler: server.RegisterServices(),
+		Handler: server.RegisterRoutes(),
 	}
+	metricsServer := &http.Server{
+		Addr:    fmt.Sprintf(":%d", server.MetricsPort),
+		Handler: server.RegisterMetricsRoutes(),
+	}
+
+	return gatewayServer, metricsServer
 }
@@ -0,0 +1,20 @@
+package service
+
+import "context"
+
+type ctxKey int
+
+const (
+	endpointCtxKey ctxKey = iota
+)
+
+// WithEndpoint stores the endpoint a request was routed to, so handlers down
+// the chain see the same endpoint even if the routes are reloaded meanwhile.
+func WithEndpoint(ctx context.Context, endpoint Endpoint) context.Context {
+	return context.WithValue(ctx, endpointCtxKey, endpoint)
+}
+
+func EndpointFromContext(ctx context.Context) (Endpoint, bool) {
+	endpoint, ok := ctx.Value(endpointCtxKey).(Endpoint)
+	return endpoint, ok
+}
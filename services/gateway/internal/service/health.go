@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_HEALTH_PATH         = "/healthz"
+	DEFAULT_HEALTH_TIMEOUT      = time.Second * 2
+	DEFAULT_HEALTH_INTERVAL     = time.Second * 10
+	DEFAULT_UNHEALTHY_THRESHOLD = 3
+)
+
+type HealthCheck struct {
+	Path    string        `yaml:"path"`
+	Timeout time.Duration `yaml:"timeout"`
+	// UnhealthyThreshold is the number of consecutive failed requests after
+	// which an upstream is ejected by passive health checking.
+	UnhealthyThreshold int `yaml:"unhealthy_threshold"`
+}
+
+// HealthChecker actively probes every upstream's health endpoint. Upstreams
+// failing a probe are ejected from their pool, upstreams passing it are put
+// back.
+type HealthChecker interface {
+	Start(ctx context.Context)
+	CheckAll(ctx context.Context)
+}
+
+type healthChecker struct {
+	interval   time.Duration
+	client     *http.Client
+	serviceMap ServiceMap
+}
+
+var healthCheckerInstance *healthChecker
+
+func NewHealthChecker(interval time.Duration, serviceMap ServiceMap) HealthChecker {
+	if healthCheckerInstance != nil {
+		return healthCheckerInstance
+	}
+
+	if interval <= 0 {
+		interval = DEFAULT_HEALTH_INTERVAL
+	}
+
+	newHealthChecker := &healthChecker{
+		interval:   interval,
+		client:     &http.Client{},
+		serviceMap: serviceMap,
+	}
+	healthCheckerInstance = newHealthChecker
+
+	return healthCheckerInstance
+}
+
+func (h *healthChecker) Start(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		h.CheckAll(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *healthChecker) CheckAll(ctx context.Context) {
+	wg := &sync.WaitGroup{}
+	for _, endpoint := range h.serviceMap.GetEndpoints() {
+		for _, upstream := range endpoint.Pool.Upstreams() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := h.check(ctx, endpoint.HealthCheck, upstream)
+				upstream.recordCheck(err)
+				if err != nil {
+					// Only log the transition, not every failed probe.
+					if upstream.Healthy() {
+						log.Printf("error: health check %v: %v", endpoint.Prefix, err)
+					}
+					upstream.MarkUnhealthy()
+					return
+				}
+				upstream.MarkHealthy()
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func (h *healthChecker) check(ctx context.Context, hc HealthCheck, upstream *Upstream) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, HealthUrl(upstream.Url, hc.Path), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v returned %d", req.URL, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// HealthUrl resolves the health path against the upstream's host. Any base
+// path on the upstream url is ignored since services serve their health
+// endpoint from the root.
+func HealthUrl(upstream *url.URL, path string) string {
+	u := url.URL{
+		Scheme: upstream.Scheme,
+		Host:   upstream.Host,
+		Path:   path,
+	}
+	return u.String()
+}
+
+func (hc HealthCheck) withDefaults() HealthCheck {
+	if hc.Path == "" {
+		hc.Path = DEFAULT_HEALTH_PATH
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = DEFAULT_HEALTH_TIMEOUT
+	}
+	if hc.UnhealthyThreshold <= 0 {
+		hc.UnhealthyThreshold = DEFAULT_UNHEALTHY_THRESHOLD
+	}
+	return hc
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	STRATEGY_ROUND_ROBIN    = "round-robin"
+	STRATEGY_LEAST_INFLIGHT = "least-inflight"
+)
+
+var ErrNoHealthyUpstream = errors.New("no healthy upstream")
+
+type Upstream struct {
+	Url      *url.URL
+	inflight atomic.Int64
+	// Upstreams start out healthy so traffic flows before the first probe.
+	unhealthy atomic.Bool
+	failures  atomic.Int32
+
+	mtx         sync.RWMutex
+	lastChecked time.Time
+	lastError   string
+}
+
+type UpstreamStatus struct {
+	Url         string    `json:"url"`
+	Healthy     bool      `json:"healthy"`
+	Inflight    int64     `json:"inflight"`
+	LastChecked time.Time `json:"last_checked,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Pool spreads the requests for one prefix across its healthy upstream
+// instances.
+type Pool struct {
+	strategy  string
+	upstreams []*Upstream
+	next      atomic.Uint64
+	threshold int32
+}
+
+func NewPool(urls []*url.URL, strategy string, unhealthyThreshold int) *Pool {
+	if strategy == "" {
+		strategy = STRATEGY_ROUND_ROBIN
+	}
+	if unhealthyThreshold <= 0 {
+		unhealthyThreshold = DEFAULT_UNHEALTHY_THRESHOLD
+	}
+
+	upstreams := make([]*Upstream, 0, len(urls))
+	for _, u := range urls {
+		upstreams = append(upstreams, &Upstream{Url: u})
+	}
+
+	return &Pool{
+		strategy:  strategy,
+		upstreams: upstreams,
+		threshold: int32(unhealthyThreshold),
+	}
+}
+
+// Acquire picks a healthy upstream and counts the request as in flight until
+// Release is called.
+func (p *Pool) Acquire() (*Upstream, error) {
+	healthy := make([]*Upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.Healthy() {
+			healthy = append(healthy, u)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyUpstream
+	}
+
+	var upstream *Upstream
+	switch p.strategy {
+	case STRATEGY_LEAST_INFLIGHT:
+		upstream = p.leastInflight(healthy)
+	default:
+		upstream = p.roundRobin(healthy)
+	}
+
+	upstream.inflight.Add(1)
+
+	return upstream, nil
+}
+
+func (p *Pool) Release(upstream *Upstream) {
+	upstream.inflight.Add(-1)
+}
+
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+func (p *Pool) Strategy() string {
+	return p.strategy
+}
+
+// ReportSuccess and ReportFailure feed passive health checking: an upstream
+// is ejected after threshold consecutive failed requests and stays out until
+// an active probe succeeds.
+func (p *Pool) ReportSuccess(upstream *Upstream) {
+	upstream.failures.Store(0)
+}
+
+func (p *Pool) ReportFailure(upstream *Upstream) {
+	if upstream.failures.Add(1) >= p.threshold {
+		upstream.MarkUnhealthy()
+	}
+}
+
+func (p *Pool) Healthy() bool {
+	for _, u := range p.upstreams {
+		if u.Healthy() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pool) roundRobin(upstreams []*Upstream) *Upstream {
+	n := p.next.Add(1) - 1
+	return upstreams[n%uint64(len(upstreams))]
+}
+
+func (p *Pool) leastInflight(upstreams []*Upstream) *Upstream {
+	// Start from a rotating offset so ties do not always land on the first
+	// upstream.
+	offset := p.next.Add(1) - 1
+	var best *Upstream
+	for i := range upstreams {
+		u := upstreams[(offset+uint64(i))%uint64(len(upstreams))]
+		if best == nil || u.Inflight() < best.Inflight() {
+			best = u
+		}
+	}
+	return best
+}
+
+func (u *Upstream) Inflight() int64 {
+	return u.inflight.Load()
+}
+
+func (u *Upstream) Healthy() bool {
+	return !u.unhealthy.Load()
+}
+
+func (u *Upstream) MarkHealthy() {
+	u.failures.Store(0)
+	if u.unhealthy.Swap(false) {
+		log.Printf("upstream %v: healthy", u)
+	}
+}
+
+func (u *Upstream) MarkUnhealthy() {
+	if !u.unhealthy.Swap(true) {
+		log.Printf("upstream %v: ejected", u)
+	}
+}
+
+func (u *Upstream) Status() UpstreamStatus {
+	u.mtx.RLock()
+	defer u.mtx.RUnlock()
+
+	return UpstreamStatus{
+		Url:         u.String(),
+		Healthy:     u.Healthy(),
+		Inflight:    u.Inflight(),
+		LastChecked: u.lastChecked,
+		LastError:   u.lastError,
+	}
+}
+
+func (u *Upstream) recordCheck(err error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	u.lastChecked = time.Now().UTC()
+	u.lastError = ""
+	if err != nil {
+		u.lastError = err.Error()
+	}
+}
+
+func (u *Upstream) String() string {
+	return u.Url.String()
+}
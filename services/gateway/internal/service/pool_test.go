@@ -0,0 +1,83 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(t *testing.T, strategy string, rawUrls ...string) *Pool {
+	urls := make([]*url.URL, 0, len(rawUrls))
+	for _, rawUrl := range rawUrls {
+		u, err := url.Parse(rawUrl)
+		require.NoError(t, err)
+		urls = append(urls, u)
+	}
+	return NewPool(urls, strategy, 2)
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	p := newTestPool(t, STRATEGY_ROUND_ROBIN, "http://a", "http://b", "http://c")
+
+	got := make([]string, 0)
+	for range 6 {
+		u, err := p.Acquire()
+		require.NoError(t, err)
+		got = append(got, u.String())
+		p.Release(u)
+	}
+
+	assert.Equal(t, []string{"http://a", "http://b", "http://c", "http://a", "http://b", "http://c"}, got)
+}
+
+func TestPoolLeastInflight(t *testing.T) {
+	p := newTestPool(t, STRATEGY_LEAST_INFLIGHT, "http://a", "http://b")
+
+	first, err := p.Acquire()
+	require.NoError(t, err)
+
+	// While the first upstream is busy every request goes to the other one.
+	for range 3 {
+		u, err := p.Acquire()
+		require.NoError(t, err)
+		assert.NotEqual(t, first, u)
+		p.Release(u)
+	}
+
+	p.Release(first)
+	assert.Equal(t, int64(0), first.Inflight())
+}
+
+func TestPoolEmpty(t *testing.T) {
+	p := NewPool(nil, "", 0)
+	_, err := p.Acquire()
+	assert.Error(t, err)
+}
+
+func TestPoolSkipsUnhealthy(t *testing.T) {
+	p := newTestPool(t, STRATEGY_ROUND_ROBIN, "http://a", "http://b")
+	a := p.Upstreams()[0]
+
+	p.ReportFailure(a)
+	assert.True(t, a.Healthy())
+	p.ReportFailure(a)
+	assert.False(t, a.Healthy())
+
+	for range 4 {
+		u, err := p.Acquire()
+		require.NoError(t, err)
+		assert.Equal(t, "http://b", u.String())
+		p.Release(u)
+	}
+
+	p.Upstreams()[1].MarkUnhealthy()
+	_, err := p.Acquire()
+	assert.ErrorIs(t, err, ErrNoHealthyUpstream)
+
+	a.MarkHealthy()
+	u, err := p.Acquire()
+	require.NoError(t, err)
+	assert.Equal(t, a, u)
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DEFAULT_RELOAD_INTERVAL = time.Second * 5
+)
+
+// Reloader keeps a ServiceMap in sync with a route file. The file is polled
+// for changes and can also be reloaded on demand by sending SIGHUP.
+type Reloader interface {
+	Start(ctx context.Context)
+	Reload() error
+}
+
+type reloader struct {
+	mtx        sync.Mutex
+	path       string
+	interval   time.Duration
+	serviceMap ServiceMap
+	modTime    time.Time
+	size       int64
+}
+
+var reloaderInstance *reloader
+
+func NewReloader(path string, interval time.Duration, serviceMap ServiceMap) Reloader {
+	if reloaderInstance != nil {
+		return reloaderInstance
+	}
+
+	if interval <= 0 {
+		interval = DEFAULT_RELOAD_INTERVAL
+	}
+
+	newReloader := &reloader{
+		mtx:        sync.Mutex{},
+		path:       path,
+		interval:   interval,
+		serviceMap: serviceMap,
+	}
+	reloaderInstance = newReloader
+
+	return reloaderInstance
+}
+
+func (r *reloader) Start(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// Remember the file the table was loaded from so the first tick does not
+	// reload it again.
+	r.changed()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Printf("error: routes reload: %v", err)
+			}
+		case <-hup:
+			log.Printf("routes reload: received SIGHUP")
+			r.changed()
+			if err := r.Reload(); err != nil {
+				log.Printf("error: routes reload: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reload replaces the endpoint table with the routes currently on disk. An
+// invalid route file leaves the current table untouched.
+func (r *reloader) Reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	routes, err := LoadRoutes(r.path)
+	if err != nil {
+		return err
+	}
+
+	diff, err := r.serviceMap.LoadRoutes(routes)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("routes reload: %v", diff)
+
+	return nil
+}
+
+func (r *reloader) changed() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		log.Printf("error: routes reload: %v", err)
+		return false
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return false
+	}
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+
+	return true
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefixes served by the gateway itself, or never proxied.
+var reservedPrefixes = map[string]bool{
+	"healthz":  true,
+	"readyz":   true,
+	"metrics":  true,
+	"internal": true,
+}
+
+const (
+	DEFAULT_BREAKER_FAILURE_THRESHOLD  = 5
+	DEFAULT_BREAKER_OPEN_TIMEOUT       = time.Second * 30
+	DEFAULT_BREAKER_HALF_OPEN_REQUESTS = 1
+)
+
+// RouteTable is the on-disk route definition. JSON is a subset of YAML, so
+// the same loader handles both formats.
+type RouteTable struct {
+	Routes []Route `yaml:"routes"`
+}
+
+type Route struct {
+	Prefix string `yaml:"prefix"`
+	// Url is shorthand for a single upstream; use Urls to balance across
+	// several instances.
+	Url      string   `yaml:"url"`
+	Urls     []string `yaml:"urls"`
+	Strategy string   `yaml:"strategy"`
+	// Scope is shorthand for a single required scope, added to Scopes.AllOf.
+	Scope  string    `yaml:"scope"`
+	Scopes ScopeRule `yaml:"scopes"`
+	// MethodScopes adds requirements for specific methods on top of Scopes,
+	// e.g. GET needs anime:read while POST needs anime:write.
+	MethodScopes map[string]ScopeRule `yaml:"method_scopes"`
+	Public       bool                 `yaml:"public"`
+	Rules        []Rule               `yaml:"rules"`
+	Timeout      time.Duration        `yaml:"timeout"`
+	// StripPrefix drops the prefix segment from the path sent upstream, e.g.
+	// /anime/1 is proxied as /1. Defaults to true, set it to false for
+	// services that mount their routes under the prefix themselves.
+	StripPrefix *bool `yaml:"strip_prefix"`
+
+	HealthCheck    HealthCheck    `yaml:"health_check"`
+	RateLimit      *RateLimit     `yaml:"rate_limit"`
+	CircuitBreaker CircuitBreaker `yaml:"circuit_breaker"`
+}
+
+// RateLimit allows Requests per Per interval for each client IP, and for each
+// user on requests with a verified token. Burst defaults to Requests.
+// A rule's rate limit replaces the route's for the requests it matches.
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	Burst    int           `yaml:"burst"`
+}
+
+// CircuitBreaker opens after FailureThreshold consecutive failed requests and
+// rejects requests for OpenTimeout before letting HalfOpenRequests probes
+// through.
+type CircuitBreaker struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+	HalfOpenRequests int           `yaml:"half_open_requests"`
+}
+
+func LoadRoutes(path string) ([]Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRoutes(data)
+}
+
+func ParseRoutes(data []byte) ([]Route, error) {
+	var table RouteTable
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("routes: %v", err)
+	}
+
+	if err := ValidateRoutes(table.Routes); err != nil {
+		return nil, err
+	}
+
+	return table.Routes, nil
+}
+
+// ValidateRoutes reports every invalid route instead of stopping at the
+// first one, so a broken route file can be fixed in a single pass.
+func ValidateRoutes(routes []Route) error {
+	if len(routes) == 0 {
+		return fmt.Errorf("routes: no routes defined")
+	}
+
+	errs := make([]error, 0)
+	seen := make(map[string]bool)
+	for i, route := range routes {
+		if err := route.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("routes[%d]: %v", i, err))
+			continue
+		}
+		if seen[route.Prefix] {
+			errs = append(errs, fmt.Errorf("routes[%d]: duplicate prefix %q", i, route.Prefix))
+			continue
+		}
+		seen[route.Prefix] = true
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Route) Validate() error {
+	if strings.TrimSpace(r.Prefix) == "" {
+		return fmt.Errorf("missing prefix")
+	}
+	if r.Prefix != strings.TrimSpace(r.Prefix) || strings.Contains(r.Prefix, "/") {
+		return fmt.Errorf("prefix %q must not contain slashes or spaces", r.Prefix)
+	}
+	if reservedPrefixes[r.Prefix] {
+		return fmt.Errorf("prefix %q is reserved by the gateway", r.Prefix)
+	}
+
+	if r.Url != "" && len(r.Urls) > 0 {
+		return fmt.Errorf("prefix %q: set either url or urls, not both", r.Prefix)
+	}
+	rawUrls := r.UpstreamUrls()
+	if len(rawUrls) == 0 {
+		return fmt.Errorf("prefix %q: missing url", r.Prefix)
+	}
+	for _, rawUrl := range rawUrls {
+		if err := validateUrl(rawUrl); err != nil {
+			return fmt.Errorf("prefix %q: %v", r.Prefix, err)
+		}
+	}
+
+	switch r.Strategy {
+	case "", STRATEGY_ROUND_ROBIN, STRATEGY_LEAST_INFLIGHT:
+	default:
+		return fmt.Errorf("prefix %q: unknown strategy %q", r.Prefix, r.Strategy)
+	}
+
+	if r.Public && r.Scope != "" {
+		return fmt.Errorf("prefix %q: public route cannot require scope %q", r.Prefix, r.Scope)
+	}
+	// Rules may still require a token for parts of a public route.
+	if r.Public && (!r.Scopes.Empty() || len(r.MethodScopes) > 0) {
+		return fmt.Errorf("prefix %q: public route cannot require scopes", r.Prefix)
+	}
+	if r.Scope != "" {
+		if err := validateScope(r.Scope); err != nil {
+			return fmt.Errorf("prefix %q: %v", r.Prefix, err)
+		}
+	}
+	if err := r.Scopes.validate(); err != nil {
+		return fmt.Errorf("prefix %q: %v", r.Prefix, err)
+	}
+	if err := validateMethodScopes(r.MethodScopes); err != nil {
+		return fmt.Errorf("prefix %q: %v", r.Prefix, err)
+	}
+	for _, rule := range r.Rules {
+		if err := rule.validate(r.Prefix); err != nil {
+			return fmt.Errorf("prefix %q: %v", r.Prefix, err)
+		}
+	}
+
+	if r.Timeout < 0 {
+		return fmt.Errorf("prefix %q: negative timeout", r.Prefix)
+	}
+
+	if r.HealthCheck.Path != "" && !strings.HasPrefix(r.HealthCheck.Path, "/") {
+		return fmt.Errorf("prefix %q: health check path %q must start with a slash", r.Prefix, r.HealthCheck.Path)
+	}
+	if r.HealthCheck.Timeout < 0 {
+		return fmt.Errorf("prefix %q: negative health check timeout", r.Prefix)
+	}
+	if r.HealthCheck.UnhealthyThreshold < 0 {
+		return fmt.Errorf("prefix %q: negative unhealthy threshold", r.Prefix)
+	}
+
+	if r.RateLimit != nil {
+		if err := r.RateLimit.validate(); err != nil {
+			return fmt.Errorf("prefix %q: %v", r.Prefix, err)
+		}
+	}
+
+	if r.CircuitBreaker.FailureThreshold < 0 {
+		return fmt.Errorf("prefix %q: negative circuit breaker failure threshold", r.Prefix)
+	}
+	if r.CircuitBreaker.OpenTimeout < 0 {
+		return fmt.Errorf("prefix %q: negative circuit breaker open timeout", r.Prefix)
+	}
+	if r.CircuitBreaker.HalfOpenRequests < 0 {
+		return fmt.Errorf("prefix %q: negative circuit breaker half open requests", r.Prefix)
+	}
+
+	return nil
+}
+
+// ScopeRule merges the Scope shorthand into Scopes.
+func (r *Route) ScopeRule() ScopeRule {
+	rule := ScopeRule{
+		AllOf: append([]string{}, r.Scopes.AllOf...),
+		AnyOf: append([]string{}, r.Scopes.AnyOf...),
+	}
+	if r.Scope != "" {
+		rule.AllOf = append(rule.AllOf, r.Scope)
+	}
+	return rule
+}
+
+// StripsPrefix reports whether the prefix is dropped before proxying.
+func (r *Route) StripsPrefix() bool {
+	return r.StripPrefix == nil || *r.StripPrefix
+}
+
+func (r *Route) UpstreamUrls() []string {
+	if r.Url != "" {
+		return []string{r.Url}
+	}
+	return r.Urls
+}
+
+func (rl *RateLimit) validate() error {
+	if rl.Requests <= 0 || rl.Per <= 0 {
+		return fmt.Errorf("rate limit needs positive requests and per")
+	}
+	if rl.Burst < 0 {
+		return fmt.Errorf("negative rate limit burst")
+	}
+	return nil
+}
+
+func (cb CircuitBreaker) withDefaults() CircuitBreaker {
+	if cb.FailureThreshold <= 0 {
+		cb.FailureThreshold = DEFAULT_BREAKER_FAILURE_THRESHOLD
+	}
+	if cb.OpenTimeout <= 0 {
+		cb.OpenTimeout = DEFAULT_BREAKER_OPEN_TIMEOUT
+	}
+	if cb.HalfOpenRequests <= 0 {
+		cb.HalfOpenRequests = DEFAULT_BREAKER_HALF_OPEN_REQUESTS
+	}
+	return cb
+}
+
+func validateUrl(rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url %q must use http or https", rawUrl)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("url %q has no host", rawUrl)
+	}
+	return nil
+}
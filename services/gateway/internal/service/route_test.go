@@ -0,0 +1,316 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRoutesYaml(t *testing.T) {
+	data := []byte(`
+routes:
+  - prefix: auth
+    url: http://auth-service
+    public: true
+  - prefix: anime
+    url: http://anime-service
+    scope: anime
+    timeout: 5s
+`)
+	routes, err := ParseRoutes(data)
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+	assert.Equal(t, "auth", routes[0].Prefix)
+	assert.True(t, routes[0].Public)
+	assert.Equal(t, "anime", routes[1].Scope)
+	assert.Equal(t, time.Second*5, routes[1].Timeout)
+}
+
+func TestParseRoutesJson(t *testing.T) {
+	data := []byte(`{"routes": [{"prefix": "anime", "url": "http://anime-service", "timeout": "1s"}]}`)
+	routes, err := ParseRoutes(data)
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	assert.Equal(t, time.Second, routes[0].Timeout)
+}
+
+func TestParseRoutesDuplicatePrefix(t *testing.T) {
+	data := []byte(`
+routes:
+  - prefix: anime
+    url: http://anime-service
+  - prefix: anime
+    url: http://anime-service-2
+`)
+	_, err := ParseRoutes(data)
+	assert.ErrorContains(t, err, "duplicate prefix")
+}
+
+func TestParseRoutesReportsAllErrors(t *testing.T) {
+	data := []byte(`
+routes:
+  - prefix: an/ime
+    url: http://anime-service
+  - prefix: auth
+    url: auth-service
+  - prefix: test
+    url: http://test-service
+    public: true
+    scope: ohfk
+`)
+	_, err := ParseRoutes(data)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "routes[0]")
+	assert.ErrorContains(t, err, "routes[1]")
+	assert.ErrorContains(t, err, "routes[2]")
+}
+
+func TestParseRoutesEmpty(t *testing.T) {
+	_, err := ParseRoutes([]byte(`routes: []`))
+	assert.Error(t, err)
+}
+
+func TestParseRoutesScopes(t *testing.T) {
+	data := []byte(`
+routes:
+  - prefix: anime
+    url: http://anime-service
+    scopes:
+      any_of: [anime:read, catalog:admin]
+    method_scopes:
+      POST:
+        all_of: [anime:write]
+`)
+	routes, err := ParseRoutes(data)
+	require.NoError(t, err)
+
+	endpoint, err := newEndpoint(routes[0])
+	require.NoError(t, err)
+
+	assert.True(t, endpoint.Authorize("GET", "anime:read"))
+	assert.True(t, endpoint.Authorize("GET", "catalog:admin"))
+	assert.True(t, endpoint.Authorize("POST", "anime:*"))
+	assert.False(t, endpoint.Authorize("GET", "user:read"))
+	assert.False(t, endpoint.Authorize("POST", "anime:read"))
+}
+
+func TestParseRoutesInvalidScopes(t *testing.T) {
+	data := []byte(`
+routes:
+  - prefix: anime
+    url: http://anime-service
+    method_scopes:
+      get:
+        all_of: [anime:read]
+  - prefix: auth
+    url: http://auth-service
+    public: true
+    scopes:
+      all_of: [auth:read]
+  - prefix: test
+    url: http://test-service
+    scopes:
+      all_of: ["test:read, test:write"]
+`)
+	_, err := ParseRoutes(data)
+	assert.ErrorContains(t, err, `routes[0]: prefix "anime": unknown method "get"`)
+	assert.ErrorContains(t, err, `routes[1]: prefix "auth": public route cannot require scopes`)
+	assert.ErrorContains(t, err, `routes[2]: prefix "test": scope "test:read, test:write" must not contain commas or spaces`)
+}
+
+func TestRuleMatches(t *testing.T) {
+	rule := Rule{Path: "/anime/*", Methods: []string{"GET"}}
+	assert.True(t, rule.Matches("GET", "/anime/1"))
+	assert.True(t, rule.Matches("GET", "/anime/1/episodes"))
+	assert.False(t, rule.Matches("GET", "/anime"))
+	assert.False(t, rule.Matches("POST", "/anime/1"))
+	assert.False(t, rule.Matches("GET", "/animes/1"))
+
+	rule = Rule{Path: "/anime"}
+	assert.True(t, rule.Matches("POST", "/anime"))
+	assert.True(t, rule.Matches("POST", "/anime/"))
+	assert.False(t, rule.Matches("POST", "/anime/1"))
+	// Paths are cleaned before matching.
+	assert.True(t, rule.Matches("POST", "/anime/1/.."))
+
+	rule = Rule{Path: "/anime/*/episodes"}
+	assert.True(t, rule.Matches("GET", "/anime/1/episodes"))
+	assert.False(t, rule.Matches("GET", "/anime/1/2/episodes"))
+}
+
+func TestParseRoutesRules(t *testing.T) {
+	data := []byte(`
+routes:
+  - prefix: anime
+    url: http://anime-service
+    rules:
+      - path: /anime/*
+        methods: [GET]
+        public: true
+      - path: /anime
+        methods: [POST]
+        scopes:
+          all_of: [catalog:admin]
+  - prefix: test
+    url: http://test-service
+    rules:
+      - path: /other/*
+      - path: /test
+        public: true
+        scopes:
+          all_of: [test:read]
+`)
+	_, err := ParseRoutes(data)
+	assert.ErrorContains(t, err, `routes[1]: prefix "test": rule path "/other/*" must start with /test`)
+
+	routes, err := ParseRoutes([]byte(strings.SplitN(string(data), "  - prefix: test", 2)[0]))
+	require.NoError(t, err)
+
+	endpoint, err := newEndpoint(routes[0])
+	require.NoError(t, err)
+
+	rule, ok := endpoint.MatchRule("GET", "/anime/1")
+	assert.True(t, ok)
+	assert.True(t, rule.Public)
+
+	rule, ok = endpoint.MatchRule("POST", "/anime")
+	assert.True(t, ok)
+	assert.False(t, rule.Public)
+	assert.True(t, rule.Scopes.Allows("catalog:admin"))
+	assert.False(t, rule.Scopes.Allows("anime:write"))
+
+	_, ok = endpoint.MatchRule("DELETE", "/anime")
+	assert.False(t, ok)
+}
+
+func TestParseRoutesRuleRateLimit(t *testing.T) {
+	_, err := ParseRoutes([]byte(`
+routes:
+  - prefix: auth
+    url: http://auth-service
+    rules:
+      - path: /auth/login
+        rate_limit:
+          requests: 0
+          per: 1m
+`))
+	assert.ErrorContains(t, err, `prefix "auth": rule path "/auth/login": rate limit needs positive requests and per`)
+
+	routes, err := ParseRoutes([]byte(`
+routes:
+  - prefix: auth
+    url: http://auth-service
+    public: true
+    rate_limit:
+      requests: 100
+      per: 1m
+    rules:
+      - path: /auth/login
+        methods: [POST]
+        public: true
+        rate_limit:
+          requests: 1
+          per: 1m
+      - path: /auth/me
+`))
+	require.NoError(t, err)
+
+	endpoint, err := newEndpoint(routes[0])
+	require.NoError(t, err)
+
+	login := endpoint.LimiterFor("POST", "/auth/login")
+	require.NotNil(t, login)
+	assert.NotSame(t, endpoint.Limiter, login)
+	allowed, _ := login.Allow("ip:1.2.3.4")
+	assert.True(t, allowed)
+	allowed, _ = login.Allow("ip:1.2.3.4")
+	assert.False(t, allowed)
+
+	// Matching a rule without a rate limit, or no rule, falls back to the
+	// route's limiter.
+	assert.Same(t, endpoint.Limiter, endpoint.LimiterFor("GET", "/auth/me"))
+	assert.Same(t, endpoint.Limiter, endpoint.LimiterFor("GET", "/auth/login"))
+	assert.Same(t, endpoint.Limiter, endpoint.LimiterFor("POST", "/auth/refresh"))
+}
+
+func TestParseRoutesStripPrefix(t *testing.T) {
+	routes, err := ParseRoutes([]byte(`
+routes:
+  - prefix: auth
+    url: http://auth-service
+    strip_prefix: false
+  - prefix: anime
+    url: http://anime-service
+`))
+	require.NoError(t, err)
+
+	auth, err := newEndpoint(routes[0])
+	require.NoError(t, err)
+	assert.False(t, auth.StripPrefix)
+	assert.Equal(t, "/auth/login", auth.UpstreamPath("/auth/login"))
+
+	anime, err := newEndpoint(routes[1])
+	require.NoError(t, err)
+	assert.True(t, anime.StripPrefix)
+	assert.Equal(t, "/1/episodes", anime.UpstreamPath("/anime/1/episodes"))
+	assert.Equal(t, "/", anime.UpstreamPath("/anime"))
+}
+
+func TestRoutesFileAuthRules(t *testing.T) {
+	routes, err := LoadRoutes("../../routes.yaml")
+	require.NoError(t, err)
+
+	var auth Endpoint
+	for _, route := range routes {
+		if route.Prefix == "auth" {
+			auth, err = newEndpoint(route)
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, "auth", auth.Prefix)
+
+	// Paths as the users service serves them, the prefix is not stripped.
+	tests := []struct {
+		method string
+		path   string
+		token  bool
+		admin  bool
+		// tight is set for the routes taking credentials, which are limited
+		// apart from the rest of the prefix.
+		tight bool
+	}{
+		{method: "POST", path: "/auth/signup", tight: true},
+		{method: "POST", path: "/auth/login", tight: true},
+		{method: "POST", path: "/auth/refresh"},
+		{method: "POST", path: "/auth/email/verify"},
+		{method: "POST", path: "/auth/password/forgot", tight: true},
+		{method: "POST", path: "/auth/password/reset", tight: true},
+		{method: "GET", path: "/auth/me", token: true},
+		{method: "POST", path: "/auth/me/password", token: true},
+		{method: "GET", path: "/auth/sessions", token: true},
+		{method: "DELETE", path: "/auth/sessions/1", token: true},
+		{method: "POST", path: "/auth/email/verify/resend", token: true},
+		{method: "PUT", path: "/auth/admin/users/1/role", token: true, admin: true},
+		{method: "POST", path: "/auth/admin/keys/rotate", token: true, admin: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.path, auth.UpstreamPath(tt.path))
+
+			rule, ok := auth.MatchRule(tt.method, tt.path)
+			assert.Equal(t, tt.token, ok && !rule.Public)
+			if tt.admin {
+				assert.True(t, rule.Scopes.Allows("users:admin"))
+				assert.False(t, rule.Scopes.Allows("users:read"))
+			}
+
+			limiter := auth.LimiterFor(tt.method, tt.path)
+			require.NotNil(t, limiter)
+			assert.Equal(t, tt.tight, limiter != auth.Limiter)
+		})
+	}
+}
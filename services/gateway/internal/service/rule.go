@@ -0,0 +1,82 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Rule refines authorization for part of a prefix. Rules are checked in
+// order and the first rule matching the request's method and path decides
+// whether a token is needed, overriding the route's public flag. Scopes of a
+// matching rule are required on top of the route's scopes.
+//
+// A rule with a rate limit limits the requests it matches separately from the
+// rest of the prefix, e.g. a tight limit on login within a generous one for
+// the whole prefix.
+//
+// Path is the full request path, including the prefix. A trailing "/*"
+// matches everything below the path, e.g. "/anime/*" matches "/anime/1" but
+// not "/anime". Other segments may use path.Match patterns.
+type Rule struct {
+	Path      string     `yaml:"path"`
+	Methods   []string   `yaml:"methods"`
+	Public    bool       `yaml:"public"`
+	Scopes    ScopeRule  `yaml:"scopes"`
+	RateLimit *RateLimit `yaml:"rate_limit"`
+}
+
+func (r *Rule) Matches(method, requestPath string) bool {
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, method) {
+		return false
+	}
+
+	requestPath = path.Clean("/" + requestPath)
+
+	if base, ok := strings.CutSuffix(r.Path, "/*"); ok {
+		return matchSubtree(base, requestPath)
+	}
+	return matchPath(r.Path, requestPath)
+}
+
+func (r *Rule) validate(prefix string) error {
+	if !strings.HasPrefix(r.Path, "/"+prefix+"/") && r.Path != "/"+prefix {
+		return fmt.Errorf("rule path %q must start with /%s", r.Path, prefix)
+	}
+	if _, err := path.Match(r.Path, ""); err != nil {
+		return fmt.Errorf("rule path %q: %v", r.Path, err)
+	}
+	for _, method := range r.Methods {
+		if !scopeMethods[method] {
+			return fmt.Errorf("rule path %q: unknown method %q", r.Path, method)
+		}
+	}
+	if r.Public && !r.Scopes.Empty() {
+		return fmt.Errorf("rule path %q: public rule cannot require scopes", r.Path)
+	}
+	if err := r.Scopes.validate(); err != nil {
+		return fmt.Errorf("rule path %q: %v", r.Path, err)
+	}
+	if r.RateLimit != nil {
+		if err := r.RateLimit.validate(); err != nil {
+			return fmt.Errorf("rule path %q: %v", r.Path, err)
+		}
+	}
+	return nil
+}
+
+// matchSubtree reports whether requestPath is below a path matching base.
+func matchSubtree(base, requestPath string) bool {
+	baseSegments := strings.Split(strings.TrimPrefix(base, "/"), "/")
+	segments := strings.Split(strings.TrimPrefix(requestPath, "/"), "/")
+	if len(segments) <= len(baseSegments) {
+		return false
+	}
+	return matchPath(base, "/"+strings.Join(segments[:len(baseSegments)], "/"))
+}
+
+func matchPath(pattern, requestPath string) bool {
+	ok, err := path.Match(pattern, requestPath)
+	return err == nil && ok
+}
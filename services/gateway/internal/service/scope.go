@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/JustinLi007/whatdoing/libs/go/util"
+)
+
+var scopeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// ScopeRule requires every scope in AllOf and at least one scope in AnyOf.
+// Granted scopes may use wildcards, e.g. "anime:*" satisfies "anime:read".
+type ScopeRule struct {
+	AllOf []string `yaml:"all_of"`
+	AnyOf []string `yaml:"any_of"`
+}
+
+func (s ScopeRule) Allows(scope string) bool {
+	return util.HasAllScopes(s.AllOf, scope) && util.HasAnyScope(s.AnyOf, scope)
+}
+
+func (s ScopeRule) Empty() bool {
+	return len(s.AllOf) == 0 && len(s.AnyOf) == 0
+}
+
+func (s ScopeRule) String() string {
+	parts := make([]string, 0, 2)
+	if len(s.AllOf) > 0 {
+		parts = append(parts, fmt.Sprintf("all of [%s]", strings.Join(s.AllOf, ", ")))
+	}
+	if len(s.AnyOf) > 0 {
+		parts = append(parts, fmt.Sprintf("any of [%s]", strings.Join(s.AnyOf, ", ")))
+	}
+	return strings.Join(parts, " and ")
+}
+
+func (s ScopeRule) validate() error {
+	for _, scope := range append(append([]string{}, s.AllOf...), s.AnyOf...) {
+		if err := validateScope(scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateScope(scope string) error {
+	if scope == "" {
+		return fmt.Errorf("empty scope")
+	}
+	if strings.ContainsAny(scope, ", \t") {
+		return fmt.Errorf("scope %q must not contain commas or spaces", scope)
+	}
+	return nil
+}
+
+func validateMethodScopes(methodScopes map[string]ScopeRule) error {
+	for method, rule := range methodScopes {
+		if !scopeMethods[method] {
+			return fmt.Errorf("unknown method %q in method scopes", method)
+		}
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("method %v: %v", method, err)
+		}
+	}
+	return nil
+}
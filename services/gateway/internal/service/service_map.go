@@ -4,13 +4,27 @@ import (
 	"bytes"
 	"fmt"
 	"net/url"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/breaker"
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/ratelimit"
 )
 
 type ServiceMap interface {
 	// Do not include slashes in the prefix.
-	AddEndpoint(rawUrl, prefix, scope string, public bool)
+	AddEndpoint(route Route) error
+	ReplaceEndpoint(route Route) error
+	RemoveEndpoint(prefix string) error
+	// LoadRoutes swaps the whole endpoint table in one step and reports what
+	// changed compared to the previous table.
+	LoadRoutes(routes []Route) (Diff, error)
 	GetEndpoint(prefix string) (Endpoint, error)
+	GetEndpoints() []Endpoint
 	PrintAll()
 }
 
@@ -20,10 +34,28 @@ type serviceMap struct {
 }
 
 type Endpoint struct {
-	Url    *url.URL
-	Prefix string
-	Scope  string
-	Public bool
+	Pool         *Pool
+	Prefix       string
+	Scopes       ScopeRule
+	MethodScopes map[string]ScopeRule
+	Public       bool
+	Rules        []Rule
+	Timeout      time.Duration
+	StripPrefix  bool
+	HealthCheck  HealthCheck
+	// Limiter is nil for endpoints without a rate limit.
+	Limiter *ratelimit.Limiter
+	Breaker *breaker.Breaker
+	route   Route
+	// ruleLimiters holds the limiter of each rule with a rate limit, by the
+	// rule's index in Rules.
+	ruleLimiters map[int]*ratelimit.Limiter
+}
+
+type Diff struct {
+	Added   []string
+	Removed []string
+	Changed []string
 }
 
 var serviceMapInstance *serviceMap
@@ -42,27 +74,95 @@ func NewServiceMap() ServiceMap {
 	return serviceMapInstance
 }
 
-func (s *serviceMap) AddEndpoint(rawUrl, prefix, scope string, public bool) {
-	// TODO: more checks
-	url, err := url.Parse(rawUrl)
+func (s *serviceMap) AddEndpoint(route Route) error {
+	if err := route.Validate(); err != nil {
+		return err
+	}
+
+	endpoint, err := newEndpoint(route)
 	if err != nil {
-		return
+		return err
 	}
 
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
-	_, ok := s.services[prefix]
+	_, ok := s.services[route.Prefix]
 	if ok {
-		return
+		return fmt.Errorf("error: prefix %q already exists", route.Prefix)
+	}
+
+	s.services[route.Prefix] = endpoint
+
+	return nil
+}
+
+func (s *serviceMap) ReplaceEndpoint(route Route) error {
+	if err := route.Validate(); err != nil {
+		return err
+	}
+
+	endpoint, err := newEndpoint(route)
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, ok := s.services[route.Prefix]
+	if !ok {
+		return fmt.Errorf("error: prefix %q does not exist", route.Prefix)
+	}
+
+	s.services[route.Prefix] = endpoint
+
+	return nil
+}
+
+func (s *serviceMap) RemoveEndpoint(prefix string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, ok := s.services[prefix]
+	if !ok {
+		return fmt.Errorf("error: prefix %q does not exist", prefix)
+	}
+
+	delete(s.services, prefix)
+
+	return nil
+}
+
+func (s *serviceMap) LoadRoutes(routes []Route) (Diff, error) {
+	if err := ValidateRoutes(routes); err != nil {
+		return Diff{}, err
 	}
 
-	s.services[prefix] = Endpoint{
-		Url:    url,
-		Prefix: prefix,
-		Scope:  scope,
-		Public: public,
+	services := make(map[string]Endpoint)
+	for _, route := range routes {
+		endpoint, err := newEndpoint(route)
+		if err != nil {
+			return Diff{}, err
+		}
+		services[route.Prefix] = endpoint
 	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// Unchanged endpoints keep their runtime state, e.g. in flight counts.
+	for prefix, endpoint := range services {
+		old, ok := s.services[prefix]
+		if ok && reflect.DeepEqual(old.route, endpoint.route) {
+			services[prefix] = old
+		}
+	}
+
+	diff := diffServices(s.services, services)
+	s.services = services
+
+	return diff, nil
 }
 
 func (s *serviceMap) GetEndpoint(prefix string) (Endpoint, error) {
@@ -77,6 +177,21 @@ func (s *serviceMap) GetEndpoint(prefix string) (Endpoint, error) {
 	return endpoint, nil
 }
 
+func (s *serviceMap) GetEndpoints() []Endpoint {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	endpoints := make([]Endpoint, 0, len(s.services))
+	for _, v := range s.services {
+		endpoints = append(endpoints, v)
+	}
+	slices.SortFunc(endpoints, func(a, b Endpoint) int {
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+
+	return endpoints
+}
+
 func (s *serviceMap) PrintAll() {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
@@ -92,10 +207,170 @@ func (e *Endpoint) String() string {
 	var buf bytes.Buffer
 
 	buf.WriteString(fmt.Sprintf("Endpoint:\n"))
-	buf.WriteString(fmt.Sprintf("Url: '%v'\n", e.Url))
+	buf.WriteString(fmt.Sprintf("Upstreams: '%v'\n", e.Pool.Upstreams()))
+	buf.WriteString(fmt.Sprintf("Strategy: '%v'\n", e.Pool.Strategy()))
 	buf.WriteString(fmt.Sprintf("Prefix: '%v'\n", e.Prefix))
-	buf.WriteString(fmt.Sprintf("Scope: '%v'\n", e.Scope))
+	buf.WriteString(fmt.Sprintf("Scopes: '%v'\n", e.Scopes))
+	buf.WriteString(fmt.Sprintf("Method Scopes: '%v'\n", e.MethodScopes))
 	buf.WriteString(fmt.Sprintf("Public: '%v'\n", e.Public))
+	buf.WriteString(fmt.Sprintf("Rules: '%v'\n", len(e.Rules)))
+	buf.WriteString(fmt.Sprintf("Timeout: '%v'\n", e.Timeout))
+	buf.WriteString(fmt.Sprintf("Strip Prefix: '%v'\n", e.StripPrefix))
 
 	return buf.String()
 }
+
+// UpstreamPath maps the incoming request path to the path sent upstream.
+func (e *Endpoint) UpstreamPath(path string) string {
+	if !e.StripPrefix {
+		return path
+	}
+	_, after, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return "/" + after
+}
+
+// InternalPath reports whether the upstream path is one of the service to
+// service routes under /internal, which the gateway never proxies.
+func InternalPath(upstreamPath string) bool {
+	upstreamPath = path.Clean("/" + upstreamPath)
+	return upstreamPath == "/internal" || strings.HasPrefix(upstreamPath, "/internal/")
+}
+
+// MatchRule returns the first rule matching the request, if any.
+func (e *Endpoint) MatchRule(method, path string) (Rule, bool) {
+	for _, rule := range e.Rules {
+		if rule.Matches(method, path) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// LimiterFor returns the limiter of the first rule matching the request if
+// that rule has a rate limit, the endpoint's limiter otherwise. It is nil when
+// neither limits the request.
+func (e *Endpoint) LimiterFor(method, path string) *ratelimit.Limiter {
+	for i, rule := range e.Rules {
+		if !rule.Matches(method, path) {
+			continue
+		}
+		if limiter, ok := e.ruleLimiters[i]; ok {
+			return limiter
+		}
+		break
+	}
+	return e.Limiter
+}
+
+// Authorize reports whether the granted scope claim satisfies the endpoint's
+// scopes and, if any, the scopes required for method.
+func (e *Endpoint) Authorize(method, scope string) bool {
+	if !e.Scopes.Allows(scope) {
+		return false
+	}
+	if rule, ok := e.MethodScopes[method]; ok {
+		return rule.Allows(scope)
+	}
+	return true
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d Diff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+
+	parts := make([]string, 0, 3)
+	if len(d.Added) > 0 {
+		parts = append(parts, fmt.Sprintf("added [%s]", strings.Join(d.Added, ", ")))
+	}
+	if len(d.Removed) > 0 {
+		parts = append(parts, fmt.Sprintf("removed [%s]", strings.Join(d.Removed, ", ")))
+	}
+	if len(d.Changed) > 0 {
+		parts = append(parts, fmt.Sprintf("changed [%s]", strings.Join(d.Changed, ", ")))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func newEndpoint(route Route) (Endpoint, error) {
+	rawUrls := route.UpstreamUrls()
+	urls := make([]*url.URL, 0, len(rawUrls))
+	for _, rawUrl := range rawUrls {
+		u, err := url.Parse(rawUrl)
+		if err != nil {
+			return Endpoint{}, err
+		}
+		urls = append(urls, u)
+	}
+
+	healthCheck := route.HealthCheck.withDefaults()
+
+	var limiter *ratelimit.Limiter
+	if route.RateLimit != nil {
+		limiter = ratelimit.NewLimiter(route.RateLimit.Requests, route.RateLimit.Per, route.RateLimit.Burst)
+	}
+	ruleLimiters := make(map[int]*ratelimit.Limiter)
+	for i, rule := range route.Rules {
+		if rule.RateLimit != nil {
+			ruleLimiters[i] = ratelimit.NewLimiter(rule.RateLimit.Requests, rule.RateLimit.Per, rule.RateLimit.Burst)
+		}
+	}
+
+	circuitBreaker := route.CircuitBreaker.withDefaults()
+
+	return Endpoint{
+		Pool:         NewPool(urls, route.Strategy, healthCheck.UnhealthyThreshold),
+		Prefix:       route.Prefix,
+		Scopes:       route.ScopeRule(),
+		MethodScopes: route.MethodScopes,
+		Rules:        route.Rules,
+		Public:       route.Public,
+		Timeout:      route.Timeout,
+		StripPrefix:  route.StripsPrefix(),
+		HealthCheck:  healthCheck,
+		Limiter:      limiter,
+		Breaker: breaker.NewBreaker(
+			route.Prefix,
+			circuitBreaker.FailureThreshold,
+			circuitBreaker.OpenTimeout,
+			circuitBreaker.HalfOpenRequests,
+		),
+		route:        route,
+		ruleLimiters: ruleLimiters,
+	}, nil
+}
+
+func diffServices(before, after map[string]Endpoint) Diff {
+	diff := Diff{
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+		Changed: make([]string, 0),
+	}
+
+	for prefix, endpoint := range after {
+		old, ok := before[prefix]
+		if !ok {
+			diff.Added = append(diff.Added, prefix)
+			continue
+		}
+		if !reflect.DeepEqual(old.route, endpoint.route) {
+			diff.Changed = append(diff.Changed, prefix)
+		}
+	}
+	for prefix := range before {
+		if _, ok := after[prefix]; !ok {
+			diff.Removed = append(diff.Removed, prefix)
+		}
+	}
+
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Removed)
+	slices.Sort(diff.Changed)
+
+	return diff
+}
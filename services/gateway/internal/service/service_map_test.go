@@ -0,0 +1,68 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServiceMap() *serviceMap {
+	return &serviceMap{
+		services: make(map[string]Endpoint),
+	}
+}
+
+func TestLoadRoutesDiff(t *testing.T) {
+	s := newTestServiceMap()
+
+	diff, err := s.LoadRoutes([]Route{
+		{Prefix: "auth", Url: "http://auth-service", Public: true},
+		{Prefix: "anime", Url: "http://anime-service"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"anime", "auth"}, diff.Added)
+
+	diff, err = s.LoadRoutes([]Route{
+		{Prefix: "anime", Url: "http://anime-service", Timeout: time.Second},
+		{Prefix: "books", Url: "http://books-service"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"books"}, diff.Added)
+	assert.Equal(t, []string{"auth"}, diff.Removed)
+	assert.Equal(t, []string{"anime"}, diff.Changed)
+
+	_, err = s.GetEndpoint("auth")
+	assert.Error(t, err)
+}
+
+func TestLoadRoutesInvalidKeepsTable(t *testing.T) {
+	s := newTestServiceMap()
+
+	_, err := s.LoadRoutes([]Route{{Prefix: "anime", Url: "http://anime-service"}})
+	require.NoError(t, err)
+
+	_, err = s.LoadRoutes([]Route{{Prefix: "anime", Url: "anime-service"}})
+	require.Error(t, err)
+
+	endpoint, err := s.GetEndpoint("anime")
+	require.NoError(t, err)
+	assert.Equal(t, "http://anime-service", endpoint.Pool.Upstreams()[0].String())
+}
+
+func TestAddReplaceRemoveEndpoint(t *testing.T) {
+	s := newTestServiceMap()
+
+	require.NoError(t, s.AddEndpoint(Route{Prefix: "anime", Url: "http://anime-service"}))
+	assert.Error(t, s.AddEndpoint(Route{Prefix: "anime", Url: "http://anime-service"}))
+
+	require.NoError(t, s.ReplaceEndpoint(Route{Prefix: "anime", Url: "http://anime-service-2"}))
+	endpoint, err := s.GetEndpoint("anime")
+	require.NoError(t, err)
+	assert.Equal(t, "http://anime-service-2", endpoint.Pool.Upstreams()[0].String())
+	assert.Error(t, s.ReplaceEndpoint(Route{Prefix: "books", Url: "http://books-service"}))
+
+	require.NoError(t, s.RemoveEndpoint("anime"))
+	assert.Error(t, s.RemoveEndpoint("anime"))
+}
@@ -2,17 +2,60 @@ package verifier
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/revocation"
+
 	"github.com/lestrrat-go/httprc/v3"
 	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
 )
 
+// Verification outcomes, used as metric labels.
+const (
+	OUTCOME_OK            = "ok"
+	OUTCOME_MISSING       = "missing"
+	OUTCOME_CONFLICT      = "conflict"
+	OUTCOME_MALFORMED     = "malformed"
+	OUTCOME_EXPIRED       = "expired"
+	OUTCOME_BAD_SIGNATURE = "bad_signature"
+	OUTCOME_INVALID       = "invalid"
+	OUTCOME_MISSING_SCOPE = "missing_scope"
+	OUTCOME_REVOKED       = "revoked"
+)
+
+// MIN_UNKNOWN_KID_REFRESH limits how often a token signed with a key missing
+// from the cached set triggers a fetch of the jwk set.
+const MIN_UNKNOWN_KID_REFRESH = time.Second * 30
+
+var ErrRevoked = errors.New("token revoked")
+
 type Verifier interface {
-	ValidateJwt(tokenStr string) (string, string, error)
+	ValidateJwt(tokenStr string) (Claims, error)
+	Status() Status
+}
+
+// Claims are the verified claims the gateway forwards downstream. Role is
+// empty for tokens issued before roles were added.
+type Claims struct {
+	Id      string
+	Subject string
+	Scope   string
+	Role    string
+}
+
+// Status describes the state of the cached JWK set used to verify tokens.
+type Status struct {
+	Url         string    `json:"url"`
+	Ready       bool      `json:"ready"`
+	Keys        int       `json:"keys"`
+	NextRefresh time.Time `json:"next_refresh,omitzero"`
+	Error       string    `json:"error,omitempty"`
 }
 
 type jwtVerifier struct {
@@ -21,11 +64,15 @@ type jwtVerifier struct {
 	issuer   string
 	audience string
 	jwkUrl   string
+	denylist revocation.Denylist
+	// lastRefresh is when an unknown kid last triggered a refresh.
+	refreshMtx  sync.Mutex
+	lastRefresh time.Time
 }
 
 var verifierInstance *jwtVerifier
 
-func NewVerifier(url, issuer, audience string) (Verifier, error) {
+func NewVerifier(url, issuer, audience string, denylist revocation.Denylist) (Verifier, error) {
 	if verifierInstance != nil {
 		return verifierInstance, nil
 	}
@@ -35,6 +82,7 @@ func NewVerifier(url, issuer, audience string) (Verifier, error) {
 		issuer:   issuer,
 		audience: audience,
 		jwkUrl:   url,
+		denylist: denylist,
 	}
 
 	wl := httprc.NewMapWhitelist().Add(url)
@@ -64,10 +112,20 @@ func NewVerifier(url, issuer, audience string) (Verifier, error) {
 	return verifierInstance, nil
 }
 
-func (v *jwtVerifier) ValidateJwt(tokenStr string) (string, string, error) {
+func (v *jwtVerifier) ValidateJwt(tokenStr string) (Claims, error) {
 	jwkSet, err := v.lookup()
 	if err != nil {
-		return "", "", err
+		return Claims{}, err
+	}
+
+	// The users service publishes a new key when it rotates, fetch the set
+	// early instead of waiting for the next scheduled refresh.
+	if kid, ok := keyId(tokenStr); ok {
+		if _, found := jwkSet.LookupKeyID(kid); !found {
+			if refreshed, ok := v.refresh(); ok {
+				jwkSet = refreshed
+			}
+		}
 	}
 
 	parsedJwt, err := jwt.ParseString(
@@ -80,23 +138,134 @@ func (v *jwtVerifier) ValidateJwt(tokenStr string) (string, string, error) {
 		jwt.WithAcceptableSkew(time.Second*30),
 	)
 	if err != nil {
-		return "", "", err
+		return Claims{}, err
 	}
 
 	sub, ok := parsedJwt.Subject()
 	if !ok {
-		return "", "", fmt.Errorf(`token have no "sub" claim`)
+		return Claims{}, fmt.Errorf(`token have no "sub" claim`)
 	}
 	if sub == "" {
-		return "", "", fmt.Errorf(`token have no "sub" claim`)
+		return Claims{}, fmt.Errorf(`token have no "sub" claim`)
+	}
+
+	jti, _ := parsedJwt.JwtID()
+	issuedAt, _ := parsedJwt.IssuedAt()
+
+	// Signing out a session denylists its id, which revokes every token
+	// issued for the session.
+	var sid string
+	if parsedJwt.Has("sid") {
+		if err := parsedJwt.Get("sid", &sid); err != nil {
+			return Claims{}, err
+		}
+	}
+
+	revoked := v.denylist.Revoked(jti, sub, issuedAt)
+	if sid != "" {
+		revoked = revoked || v.denylist.Revoked(sid, sub, issuedAt)
+	}
+	if revoked {
+		return Claims{}, ErrRevoked
 	}
 
 	var scope string
 	if err := parsedJwt.Get("scope", &scope); err != nil {
-		return "", "", err
+		return Claims{}, err
+	}
+
+	var role string
+	if parsedJwt.Has("role") {
+		if err := parsedJwt.Get("role", &role); err != nil {
+			return Claims{}, err
+		}
 	}
 
-	return sub, scope, nil
+	return Claims{
+		Id:      jti,
+		Subject: sub,
+		Scope:   scope,
+		Role:    role,
+	}, nil
+}
+
+func (v *jwtVerifier) Status() Status {
+	v.mtx.RLock()
+	defer v.mtx.RUnlock()
+
+	status := Status{
+		Url: v.jwkUrl,
+	}
+
+	resource, err := v.jwkCache.LookupResource(context.Background(), v.jwkUrl)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	set := resource.Resource()
+	if set == nil {
+		status.Error = "jwk set not fetched yet"
+		return status
+	}
+
+	status.Keys = set.Len()
+	status.Ready = status.Keys > 0
+	status.NextRefresh = resource.Next()
+	if !status.Ready {
+		status.Error = "jwk set has no keys"
+	}
+
+	return status
+}
+
+// Outcome classifies an error returned by ValidateJwt.
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return OUTCOME_OK
+	case errors.Is(err, ErrRevoked):
+		return OUTCOME_REVOKED
+	case errors.Is(err, jwt.TokenExpiredError()):
+		return OUTCOME_EXPIRED
+	// A token that is not a jws at all also fails verification, check for
+	// parse errors first.
+	case errors.Is(err, jws.ParseError()):
+		return OUTCOME_MALFORMED
+	case errors.Is(err, jws.VerifyError()), errors.Is(err, jws.VerificationError()):
+		return OUTCOME_BAD_SIGNATURE
+	default:
+		return OUTCOME_INVALID
+	}
+}
+
+// refresh fetches the jwk set anew, at most once per MIN_UNKNOWN_KID_REFRESH.
+func (v *jwtVerifier) refresh() (jwk.Set, bool) {
+	v.refreshMtx.Lock()
+	defer v.refreshMtx.Unlock()
+
+	if time.Since(v.lastRefresh) < MIN_UNKNOWN_KID_REFRESH {
+		return nil, false
+	}
+	v.lastRefresh = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	set, err := v.jwkCache.Refresh(ctx, v.jwkUrl)
+	if err != nil {
+		log.Printf("error: jwk set refresh: %v", err)
+		return nil, false
+	}
+	return set, true
+}
+
+// keyId reads the "kid" header without verifying the token.
+func keyId(tokenStr string) (string, bool) {
+	msg, err := jws.Parse([]byte(tokenStr))
+	if err != nil || len(msg.Signatures()) == 0 {
+		return "", false
+	}
+	return msg.Signatures()[0].ProtectedHeaders().KeyID()
 }
 
 func (v *jwtVerifier) lookup() (jwk.Set, error) {
@@ -0,0 +1,106 @@
+package verifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/services/gateway/internal/revocation"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutcome(t *testing.T) {
+	signingKey := newTestKey(t, "a")
+	otherKey := newTestKey(t, "a")
+
+	publicKey, err := signingKey.PublicKey()
+	require.NoError(t, err)
+	set := jwk.NewSet()
+	require.NoError(t, set.AddKey(publicKey))
+
+	parse := func(token string) error {
+		_, err := jwt.ParseString(token, jwt.WithKeySet(set), jwt.WithValidate(true))
+		return err
+	}
+
+	assert.Equal(t, OUTCOME_OK, Outcome(parse(signTestJwt(t, signingKey, time.Hour))))
+	assert.Equal(t, OUTCOME_EXPIRED, Outcome(parse(signTestJwt(t, signingKey, -time.Hour))))
+	assert.Equal(t, OUTCOME_BAD_SIGNATURE, Outcome(parse(signTestJwt(t, otherKey, time.Hour))))
+	assert.Equal(t, OUTCOME_MALFORMED, Outcome(parse("not.a.jwt")))
+	assert.Equal(t, OUTCOME_REVOKED, Outcome(ErrRevoked))
+}
+
+func TestValidateJwtRevokedSession(t *testing.T) {
+	signingKey := newTestKey(t, "a")
+	publicKey, err := signingKey.PublicKey()
+	require.NoError(t, err)
+	set := jwk.NewSet()
+	require.NoError(t, set.AddKey(publicKey))
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer jwks.Close()
+
+	denylist := revocation.NewDenylist()
+	v, err := NewVerifier(jwks.URL, "iss", "aud", denylist)
+	require.NoError(t, err)
+
+	sign := func(sid string) string {
+		token, err := jwt.NewBuilder().
+			Issuer("iss").
+			Audience([]string{"aud"}).
+			Subject("user").
+			IssuedAt(time.Now()).
+			Expiration(time.Now().Add(time.Hour)).
+			JwtID(sid+"-jti").
+			Claim("scope", "").
+			Claim("sid", sid).
+			Build()
+		require.NoError(t, err)
+		signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256(), signingKey))
+		require.NoError(t, err)
+		return string(signed)
+	}
+
+	denylist.Add("revoked-session", time.Now().Add(time.Hour))
+
+	_, err = v.ValidateJwt(sign("revoked-session"))
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	claims, err := v.ValidateJwt(sign("other-session"))
+	require.NoError(t, err)
+	assert.Equal(t, "user", claims.Subject)
+}
+
+func newTestKey(t *testing.T, kid string) jwk.Key {
+	raw, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key, err := jwk.Import(raw)
+	require.NoError(t, err)
+	require.NoError(t, key.Set(jwk.KeyIDKey, kid))
+	require.NoError(t, key.Set(jwk.AlgorithmKey, jwa.ES256()))
+	return key
+}
+
+func signTestJwt(t *testing.T, key jwk.Key, expiresIn time.Duration) string {
+	token, err := jwt.NewBuilder().
+		Subject("user").
+		Expiration(time.Now().Add(expiresIn)).
+		Build()
+	require.NoError(t, err)
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256(), key))
+	require.NoError(t, err)
+	return string(signed)
+}
@@ -22,6 +22,7 @@ type ServiceAnimeProgress interface {
 	GetAnimeProgress(reqProgress *AnimeProgress) (*AnimeProgress, error)
 	UpdateAnimeProgress(reqProgress *AnimeProgress) (*AnimeProgress, error)
 	DeleteAnimeProgress(reqProgress *AnimeProgress) error
+	DeleteUserData(reqDeletion *UserDeletion) (*UserDeletion, error)
 }
 
 type serviceAnimeProgress struct {
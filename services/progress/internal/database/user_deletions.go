@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrUserDeletionProcessed = errors.New("user deletion already processed")
+
+// UserDeletion records that the data of a deleted user was removed, once per
+// user.deleted event.
+type UserDeletion struct {
+	EventId         uuid.UUID `json:"event_id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UserId          uuid.UUID `json:"user_id"`
+	DeletedAt       time.Time `json:"deleted_at"`
+	ProgressDeleted int       `json:"progress_deleted"`
+}
+
+// DeleteUserData removes the progress of the deleted user and records it. It
+// returns ErrUserDeletionProcessed, removing nothing, if the event was already
+// processed.
+func (s *serviceAnimeProgress) DeleteUserData(reqDeletion *UserDeletion) (*UserDeletion, error) {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	n, err := DeleteAnimeProgressByUserId(tx, reqDeletion)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := InsertUserDeletion(tx, &UserDeletion{
+		EventId:         reqDeletion.EventId,
+		UserId:          reqDeletion.UserId,
+		DeletedAt:       reqDeletion.DeletedAt,
+		ProgressDeleted: n,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserDeletionProcessed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func DeleteAnimeProgressByUserId(tx *sql.Tx, reqDeletion *UserDeletion) (int, error) {
+	query := `
+	DELETE FROM anime_progress
+	WHERE user_id = $1
+	`
+
+	queryResult, err := tx.Exec(
+		query,
+		reqDeletion.UserId,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := queryResult.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// InsertUserDeletion returns sql.ErrNoRows if the event was already recorded.
+func InsertUserDeletion(tx *sql.Tx, reqDeletion *UserDeletion) (*UserDeletion, error) {
+	query := `
+	INSERT INTO user_deletions (event_id, user_id, deleted_at, progress_deleted)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT DO NOTHING
+	RETURNING event_id, created_at, user_id, deleted_at, progress_deleted
+	`
+
+	result := &UserDeletion{}
+
+	if err := tx.QueryRow(
+		query,
+		reqDeletion.EventId,
+		reqDeletion.UserId,
+		reqDeletion.DeletedAt,
+		reqDeletion.ProgressDeleted,
+	).Scan(
+		&result.EventId,
+		&result.CreatedAt,
+		&result.UserId,
+		&result.DeletedAt,
+		&result.ProgressDeleted,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
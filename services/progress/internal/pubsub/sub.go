@@ -8,9 +8,11 @@ import (
 
 	"github.com/JustinLi007/whatdoing/libs/go/config"
 	"github.com/JustinLi007/whatdoing/libs/go/pubsub"
+	"github.com/JustinLi007/whatdoing/libs/go/requestid"
 	"github.com/JustinLi007/whatdoing/libs/go/util"
 	"github.com/JustinLi007/whatdoing/services/progress/internal/database"
 
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -60,24 +62,91 @@ func newSubscriber(animeProgressService database.ServiceAnimeProgress) Subscribe
 	return subscriberInstance
 }
 
-func handlerFoo(e *database.AnimeProgress) pubsub.MessageHandler[*database.AnimeProgress] {
-	return func(t *database.AnimeProgress) pubsub.AckType {
-		log.Println("test message handler...")
-		return pubsub.NACK_REQUEUE
+// AnimeEvent is an anime outbox event as published by the anime service.
+type AnimeEvent struct {
+	Id        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	AnimeId   uuid.UUID `json:"anime_id"`
+	Name      string    `json:"name"`
+	Episodes  int       `json:"episodes"`
+	EventType string    `json:"event_type"`
+	RequestId string    `json:"request_id"`
+}
+
+// handlerAnimeEvent acknowledges anime events after logging them, the
+// progress service does not act on them yet.
+func handlerAnimeEvent() pubsub.MessageHandler[*AnimeEvent] {
+	return func(e *AnimeEvent) pubsub.AckType {
+		requestid.Logf(e.RequestId, "received event %v anime.%s for anime %v", e.Id, e.EventType, e.AnimeId)
+		return pubsub.ACK
+	}
+}
+
+// UserDeletedEvent is a user.deleted outbox event as published by the users
+// service.
+type UserDeletedEvent struct {
+	Id        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	EventType string    `json:"event_type"`
+	Payload   struct {
+		UserId    uuid.UUID `json:"user_id"`
+		DeletedAt time.Time `json:"deleted_at"`
+	} `json:"payload"`
+}
+
+// handlerUserDeleted removes the progress of deleted users. Redelivered events
+// are acknowledged without doing anything, failures are retried.
+func handlerUserDeleted(animeProgressService database.ServiceAnimeProgress) pubsub.MessageHandler[*UserDeletedEvent] {
+	return func(e *UserDeletedEvent) pubsub.AckType {
+		if e.Payload.UserId == uuid.Nil {
+			log.Printf("error: event %v user.deleted has no user id", e.Id)
+			return pubsub.NACK
+		}
+
+		dbDeletion, err := animeProgressService.DeleteUserData(&database.UserDeletion{
+			EventId:   e.Id,
+			UserId:    e.Payload.UserId,
+			DeletedAt: e.Payload.DeletedAt,
+		})
+		if errors.Is(err, database.ErrUserDeletionProcessed) {
+			log.Printf("event %v user.deleted already processed", e.Id)
+			return pubsub.ACK
+		}
+		if err != nil {
+			log.Printf("error: event %v user.deleted: %v", e.Id, err)
+			return pubsub.NACK_REQUEUE
+		}
+
+		log.Printf("event %v user.deleted: removed %d progress of user %v", e.Id, dbDeletion.ProgressDeleted, dbDeletion.UserId)
+		return pubsub.ACK
 	}
 }
 
 func (s *subscriber) Start(ctx context.Context) {
-	// TODO:
-	pubsub.SubscribeJSON(
+	if err := pubsub.SubscribeJSON(
 		s.ch,
 		"whatdoing",
 		"",
 		"anime.*",
 		pubsub.QUEUE_TYPE_TRANSIENT,
 		nil,
-		handlerFoo(nil),
-	)
+		handlerAnimeEvent(),
+	); err != nil {
+		log.Printf("error: %v", err)
+	}
+
+	// Durable so deletions published while the subscriber is down are not lost.
+	if err := pubsub.SubscribeJSON(
+		s.ch,
+		"whatdoing",
+		"progress.user.deleted",
+		"user.deleted",
+		pubsub.QUEUE_TYPE_DURABLE,
+		nil,
+		handlerUserDeleted(s.animeProgressService),
+	); err != nil {
+		log.Printf("error: %v", err)
+	}
 }
 
 func (s *subscriber) connect() {
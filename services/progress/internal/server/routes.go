@@ -3,12 +3,14 @@ package server
 import (
 	"net/http"
 
+	"github.com/JustinLi007/whatdoing/libs/go/requestid"
 	"github.com/JustinLi007/whatdoing/libs/go/util"
 	"github.com/go-chi/chi/v5"
 )
 
 func (s *Server) RegisterRoutes() *chi.Mux {
 	mux := chi.NewRouter()
+	mux.Use(requestid.Middleware)
 
 	mux.Get("/healthz", s.Healthz)
 
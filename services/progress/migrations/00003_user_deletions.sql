@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_deletions (
+  event_id UUID PRIMARY KEY,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  user_id UUID NOT NULL,
+  deleted_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  progress_deleted INT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS user_deletions_user_id_idx ON user_deletions (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE user_deletions;
+-- +goose StatementEnd
@@ -11,34 +11,73 @@ import (
 	"time"
 
 	"github.com/JustinLi007/whatdoing/libs/go/config"
+	"github.com/JustinLi007/whatdoing/services/users/internal/pubsub"
 	"github.com/JustinLi007/whatdoing/services/users/internal/server"
 )
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	done := make(chan bool, 1)
-
 	c := config.NewBuilder().
-		// Env("DB_URL").
+		Cli("mode").
+		Cli("env").
+		Env("SERVER_PORT").
+		Env("INTERNAL_PORT").
+		Env("DB_URL").
 		Env("JWT_ISSUER").
 		Env("JWT_AUDIENCE").
+		Env("SIGNING_KEYS_DIR").
+		Env("SIGNING_KEYS_FILE").
+		Env("SIGNING_KEY_ROTATION_INTERVAL").
+		Env("APP_URL").
+		Env("MAILER").
+		Env("MAILER_FILE").
+		Env("SMTP_HOST").
+		Env("SMTP_PORT").
+		Env("SMTP_USERNAME").
+		Env("SMTP_PASSWORD").
+		Env("SMTP_FROM").
+		Env("CORS_ALLOWED_ORIGINS").
+		Env("CORS_ALLOWED_METHODS").
+		Env("CORS_ALLOWED_HEADERS").
+		Env("CORS_MAX_AGE").
+		Env("CORS_ALLOW_CREDENTIALS").
 		Build()
 	c.Parse()
 
-	server := server.NewServer(ctx, c)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool, 1)
+
+	switch c.Get("mode") {
+	case "service":
+		server, internalServer := server.NewServer(ctx, c)
+
+		go gracefullShutdown(done, cancel, server, internalServer)
+
+		go func() {
+			log.Printf("internal listening on %v", internalServer.Addr)
+			if err := internalServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("error: %v", err)
+			}
+		}()
 
-	go gracefullShutdown(server, done, cancel)
+		log.Printf("listening on %v", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("error: %v", err)
+		}
+	case "pub":
+		pub := pubsub.NewPublisher(c)
 
-	log.Printf("listening on %v", server.Addr)
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("error: %v", err)
+		go gracefulShutdownPub(done, cancel)
+
+		pub.Start(ctx)
+	default:
+		log.Panicf("error: unknown mode")
 	}
 
 	<-done
 	log.Println("Graceful shutdown complete.")
 }
 
-func gracefullShutdown(server *http.Server, done chan bool, ctxCancel context.CancelFunc) {
+func gracefullShutdown(done chan bool, ctxCancel context.CancelFunc, servers ...*http.Server) {
 	signalCtx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 	<-signalCtx.Done()
@@ -50,10 +89,26 @@ func gracefullShutdown(server *http.Server, done chan bool, ctxCancel context.Ca
 
 	timeoutCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
-	if err := server.Shutdown(timeoutCtx); err != nil {
-		log.Printf("Server forced to shutdown with error: %v", err)
+	for _, server := range servers {
+		if err := server.Shutdown(timeoutCtx); err != nil {
+			log.Printf("Server forced to shutdown with error: %v", err)
+		}
 	}
 
 	log.Println("Server exiting")
 	done <- true
 }
+
+func gracefulShutdownPub(done chan bool, ctxCancel context.CancelFunc) {
+	signalCtx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	<-signalCtx.Done()
+
+	ctxCancel()
+
+	fmt.Println()
+	log.Println("shutting down gracefully, press Ctrl+C again to force")
+
+	log.Println("publisher exiting")
+	done <- true
+}
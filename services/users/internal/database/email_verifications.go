@@ -0,0 +1,153 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/services/users/internal/token"
+
+	"github.com/google/uuid"
+)
+
+var ErrVerificationTokenInvalid = errors.New("verification token invalid")
+
+// EmailVerification proves ownership of Email, the address the user had when
+// the token was issued.
+type EmailVerification struct {
+	Id        uuid.UUID
+	CreatedAt time.Time
+	UserId    uuid.UUID
+	Email     string
+	Token     *token.Token
+	UsedAt    *time.Time
+}
+
+// InsertEmailVerification issues a token for the user's current email and
+// invalidates the ones issued before it.
+func InsertEmailVerification(tx *sql.Tx, reqVerification *EmailVerification) (*EmailVerification, error) {
+	query := `
+	UPDATE email_verification_tokens
+	SET used_at = NOW()
+	WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	if _, err := tx.Exec(
+		query,
+		reqVerification.UserId,
+	); err != nil {
+		return nil, err
+	}
+
+	query = `
+	INSERT INTO email_verification_tokens (id, user_id, email, hash, expiry)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, user_id, email, hash, expiry
+	`
+
+	result := &EmailVerification{
+		Token: &token.Token{
+			PlainText: reqVerification.Token.PlainText,
+		},
+	}
+
+	if err := tx.QueryRow(
+		query,
+		uuid.New(),
+		reqVerification.UserId,
+		reqVerification.Email,
+		reqVerification.Token.GetHash(),
+		reqVerification.Token.Expiry,
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UserId,
+		&result.Email,
+		&result.Token.Hash,
+		&result.Token.Expiry,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func SelectEmailVerificationByHash(tx *sql.Tx, reqVerification *EmailVerification) (*EmailVerification, error) {
+	query := `
+	SELECT id, created_at, user_id, email, hash, expiry, used_at
+	FROM email_verification_tokens
+	WHERE hash = $1
+	FOR UPDATE
+	`
+
+	result := &EmailVerification{
+		Token: &token.Token{},
+	}
+
+	if err := tx.QueryRow(
+		query,
+		reqVerification.Token.GetHash(),
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UserId,
+		&result.Email,
+		&result.Token.Hash,
+		&result.Token.Expiry,
+		&result.UsedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func UpdateEmailVerificationUsed(tx *sql.Tx, reqVerification *EmailVerification) error {
+	query := `
+	UPDATE email_verification_tokens
+	SET used_at = NOW()
+	WHERE id = $1
+	`
+
+	if _, err := tx.Exec(
+		query,
+		reqVerification.Id,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateUserEmailVerified marks the user verified, provided their email is
+// still the one the token was issued for.
+func UpdateUserEmailVerified(tx *sql.Tx, reqVerification *EmailVerification) (*User, error) {
+	query := `
+	UPDATE users
+	SET
+		updated_at = NOW(),
+		email_verified_at = NOW()
+	WHERE id = $1 AND email = $2
+	RETURNING id, created_at, updated_at, username, email, role, email_verified_at
+	`
+
+	result := &User{}
+
+	if err := tx.QueryRow(
+		query,
+		reqVerification.UserId,
+		reqVerification.Email,
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UpdatedAt,
+		&result.Username,
+		&result.Email,
+		&result.Role,
+		&result.EmailVerifiedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
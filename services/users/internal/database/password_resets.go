@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/services/users/internal/token"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrResetTokenInvalid = errors.New("password reset token invalid")
+	ErrResetThrottled    = errors.New("password reset requested too recently")
+)
+
+type PasswordReset struct {
+	Id        uuid.UUID
+	CreatedAt time.Time
+	UserId    uuid.UUID
+	Token     *token.Token
+	UsedAt    *time.Time
+}
+
+// InsertPasswordReset issues a reset token and invalidates the ones issued
+// before it.
+func InsertPasswordReset(tx *sql.Tx, reqReset *PasswordReset) (*PasswordReset, error) {
+	if err := UpdatePasswordResetsUsed(tx, &User{Id: reqReset.UserId}); err != nil {
+		return nil, err
+	}
+
+	query := `
+	INSERT INTO password_reset_tokens (id, user_id, hash, expiry)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, user_id, hash, expiry
+	`
+
+	result := &PasswordReset{
+		Token: &token.Token{
+			PlainText: reqReset.Token.PlainText,
+		},
+	}
+
+	if err := tx.QueryRow(
+		query,
+		uuid.New(),
+		reqReset.UserId,
+		reqReset.Token.GetHash(),
+		reqReset.Token.Expiry,
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UserId,
+		&result.Token.Hash,
+		&result.Token.Expiry,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func SelectPasswordResetByHash(tx *sql.Tx, reqReset *PasswordReset) (*PasswordReset, error) {
+	query := `
+	SELECT id, created_at, user_id, hash, expiry, used_at
+	FROM password_reset_tokens
+	WHERE hash = $1
+	FOR UPDATE
+	`
+
+	result := &PasswordReset{
+		Token: &token.Token{},
+	}
+
+	if err := tx.QueryRow(
+		query,
+		reqReset.Token.GetHash(),
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UserId,
+		&result.Token.Hash,
+		&result.Token.Expiry,
+		&result.UsedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SelectPasswordResetIssuedSince reports whether the user has an unused reset
+// token issued after since.
+func SelectPasswordResetIssuedSince(tx *sql.Tx, reqUser *User, since time.Time) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM password_reset_tokens
+		WHERE user_id = $1 AND used_at IS NULL AND created_at > $2
+	)
+	`
+
+	var result bool
+	if err := tx.QueryRow(
+		query,
+		reqUser.Id,
+		since,
+	).Scan(
+		&result,
+	); err != nil {
+		return false, err
+	}
+
+	return result, nil
+}
+
+// UpdatePasswordResetsUsed marks every outstanding reset token of the user
+// used.
+func UpdatePasswordResetsUsed(tx *sql.Tx, reqUser *User) error {
+	query := `
+	UPDATE password_reset_tokens
+	SET used_at = NOW()
+	WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	if _, err := tx.Exec(
+		query,
+		reqUser.Id,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/services/users/internal/token"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// RefreshToken belongs to a session started at sign up or login. Every refresh
+// rotates the token within the session, presenting a rotated token again
+// revokes the whole session.
+type RefreshToken struct {
+	Id        uuid.UUID
+	CreatedAt time.Time
+	UserId    uuid.UUID
+	SessionId uuid.UUID
+	Token     *token.Token
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+}
+
+func InsertRefreshToken(tx *sql.Tx, reqToken *RefreshToken) (*RefreshToken, error) {
+	query := `
+	INSERT INTO refresh_tokens (id, user_id, session_id, hash, expiry)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, user_id, session_id, hash, expiry
+	`
+
+	result := &RefreshToken{
+		Token: &token.Token{
+			PlainText: reqToken.Token.PlainText,
+		},
+	}
+
+	if err := tx.QueryRow(
+		query,
+		uuid.New(),
+		reqToken.UserId,
+		reqToken.SessionId,
+		reqToken.Token.GetHash(),
+		reqToken.Token.Expiry,
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UserId,
+		&result.SessionId,
+		&result.Token.Hash,
+		&result.Token.Expiry,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SelectRefreshTokenByHash locks the row so concurrent refreshes with the same
+// token are serialized.
+func SelectRefreshTokenByHash(tx *sql.Tx, reqToken *RefreshToken) (*RefreshToken, error) {
+	query := `
+	SELECT id, created_at, user_id, session_id, hash, expiry, rotated_at, revoked_at
+	FROM refresh_tokens
+	WHERE hash = $1
+	FOR UPDATE
+	`
+
+	result := &RefreshToken{
+		Token: &token.Token{},
+	}
+
+	if err := tx.QueryRow(
+		query,
+		reqToken.Token.GetHash(),
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UserId,
+		&result.SessionId,
+		&result.Token.Hash,
+		&result.Token.Expiry,
+		&result.RotatedAt,
+		&result.RevokedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func UpdateRefreshTokenRotated(tx *sql.Tx, reqToken *RefreshToken) error {
+	query := `
+	UPDATE refresh_tokens
+	SET rotated_at = NOW()
+	WHERE id = $1 AND rotated_at IS NULL
+	`
+
+	queryResult, err := tx.Exec(
+		query,
+		reqToken.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := queryResult.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
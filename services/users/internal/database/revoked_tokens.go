@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/services/users/internal/token"
+
+	"github.com/google/uuid"
+)
+
+// RevokedToken is a jwt that must be rejected until it expires on its own.
+// Jti is either the token's "jti" or the "sid" of a revoked session, which
+// covers every token issued for the session.
+type RevokedToken struct {
+	Jti       string    `json:"jti"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RevokedUser revokes every token of the user issued before NotBefore.
+type RevokedUser struct {
+	UserId    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	NotBefore time.Time `json:"not_before"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// InsertRevokedToken denylists the jwt and queues an auth.revoked event.
+func InsertRevokedToken(tx *sql.Tx, reqToken *RevokedToken) error {
+	query := `
+	INSERT INTO revoked_tokens (jti, expires_at)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING
+	`
+
+	if _, err := tx.Exec(
+		query,
+		reqToken.Jti,
+		reqToken.ExpiresAt,
+	); err != nil {
+		return err
+	}
+
+	return InsertEvent(tx, EVENT_AUTH_REVOKED, &RevokedEvent{
+		Jti:       reqToken.Jti,
+		ExpiresAt: reqToken.ExpiresAt,
+	})
+}
+
+// InsertRevokedUser revokes every jwt issued to the user so far and queues an
+// auth.revoked event. It replaces any earlier revocation of the user.
+func InsertRevokedUser(tx *sql.Tx, reqUser *User) error {
+	query := `
+	INSERT INTO revoked_users (user_id, created_at, not_before, expires_at)
+	VALUES ($1, NOW(), NOW(), $2)
+	ON CONFLICT (user_id) DO UPDATE
+	SET
+		created_at = EXCLUDED.created_at,
+		not_before = EXCLUDED.not_before,
+		expires_at = EXCLUDED.expires_at
+	RETURNING not_before, expires_at
+	`
+
+	result := &RevokedUser{
+		UserId: reqUser.Id,
+	}
+
+	if err := tx.QueryRow(
+		query,
+		reqUser.Id,
+		time.Now().Add(token.JWT_TTL).UTC(),
+	).Scan(
+		&result.NotBefore,
+		&result.ExpiresAt,
+	); err != nil {
+		return err
+	}
+
+	return InsertEvent(tx, EVENT_AUTH_REVOKED, &RevokedEvent{
+		UserId:    &result.UserId,
+		NotBefore: &result.NotBefore,
+		ExpiresAt: result.ExpiresAt,
+	})
+}
+
+// SelectRevokedUsers returns the unexpired user revocations made after since.
+func SelectRevokedUsers(tx *sql.Tx, since time.Time) ([]*RevokedUser, error) {
+	query := `
+	SELECT user_id, created_at, not_before, expires_at
+	FROM revoked_users
+	WHERE created_at > $1 AND expires_at > NOW()
+	ORDER BY created_at
+	`
+
+	rows, err := tx.Query(
+		query,
+		since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*RevokedUser, 0)
+	for rows.Next() {
+		revokedUser := &RevokedUser{}
+		if err := rows.Scan(
+			&revokedUser.UserId,
+			&revokedUser.CreatedAt,
+			&revokedUser.NotBefore,
+			&revokedUser.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, revokedUser)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SelectRevokedTokens returns the unexpired tokens revoked after since.
+func SelectRevokedTokens(tx *sql.Tx, since time.Time) ([]*RevokedToken, error) {
+	query := `
+	SELECT jti, created_at, expires_at
+	FROM revoked_tokens
+	WHERE created_at > $1 AND expires_at > NOW()
+	ORDER BY created_at
+	`
+
+	rows, err := tx.Query(
+		query,
+		since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*RevokedToken, 0)
+	for rows.Next() {
+		revokedToken := &RevokedToken{}
+		if err := rows.Scan(
+			&revokedToken.Jti,
+			&revokedToken.CreatedAt,
+			&revokedToken.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, revokedToken)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func DeleteExpiredRevokedTokens(tx *sql.Tx) error {
+	query := `
+	DELETE FROM revoked_tokens
+	WHERE expires_at <= NOW()
+	`
+
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+
+	query = `
+	DELETE FROM revoked_users
+	WHERE expires_at <= NOW()
+	`
+
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+
+	return nil
+}
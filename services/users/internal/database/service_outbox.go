@@ -0,0 +1,303 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type EventStatus string
+type EventType string
+
+const (
+	STATUS_INCOMPLETE = "incomplete"
+	STATUS_PENDING    = "pending"
+	STATUS_COMPLETED  = "completed"
+)
+
+// PENDING_TIMEOUT is how long an event may stay pending before it is taken as
+// abandoned by a publisher that crashed mid publish, and retried. Events are
+// therefore delivered at least once, consumers must handle duplicates.
+const PENDING_TIMEOUT = time.Minute
+
+// Event types double as the routing keys events are published with.
+const (
+	EVENT_AUTH_REVOKED = "auth.revoked"
+	EVENT_USER_DELETED = "user.deleted"
+)
+
+type Event struct {
+	Id        uuid.UUID       `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	EventType EventType       `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    EventStatus     `json:"status"`
+}
+
+// RevokedEvent is the payload of auth.revoked. It revokes either the token
+// Jti, or every token of UserId issued before NotBefore. ExpiresAt is when the
+// revoked tokens expire on their own.
+type RevokedEvent struct {
+	Jti       string     `json:"jti,omitempty"`
+	UserId    *uuid.UUID `json:"user_id,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+// UserDeletedEvent is the payload of user.deleted. Services holding data of
+// the user remove it when they receive it.
+type UserDeletedEvent struct {
+	UserId    uuid.UUID `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type ServiceOutbox interface {
+	GetIncomplete() (*Event, error)
+	// ReclaimPending puts events pending for longer than timeout back to
+	// incomplete and reports how many there were.
+	ReclaimPending(timeout time.Duration) (int64, error)
+	MarkIncomplete(reqEvent *Event) (*Event, error)
+	MarkCompleted(reqEvent *Event) (*Event, error)
+}
+
+type serviceOutbox struct {
+	db ServiceDb
+}
+
+var serviceOutboxInstance *serviceOutbox
+
+func NewServiceOutbox(db ServiceDb) ServiceOutbox {
+	if serviceOutboxInstance != nil {
+		return serviceOutboxInstance
+	}
+	newServiceOutbox := &serviceOutbox{
+		db: db,
+	}
+	serviceOutboxInstance = newServiceOutbox
+	return serviceOutboxInstance
+}
+
+func (o *serviceOutbox) GetIncomplete() (*Event, error) {
+	tx, err := o.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := SelectIncomplete(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (o *serviceOutbox) ReclaimPending(timeout time.Duration) (int64, error) {
+	tx, err := o.db.Conn().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := UpdateStalePending(tx, timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return result, nil
+}
+
+func (o *serviceOutbox) MarkIncomplete(reqEvent *Event) (*Event, error) {
+	tx, err := o.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := UpdateEventStatus(tx, reqEvent, STATUS_INCOMPLETE)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (o *serviceOutbox) MarkCompleted(reqEvent *Event) (*Event, error) {
+	tx, err := o.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := UpdateEventStatus(tx, reqEvent, STATUS_COMPLETED)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func InsertEvent(tx *sql.Tx, eventType EventType, payload any) error {
+	query := `
+	INSERT INTO outbox (id, event_type, payload, status)
+	VALUES ($1, $2, $3, $4)
+	`
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		query,
+		uuid.New(),
+		eventType,
+		data,
+		STATUS_INCOMPLETE,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func SelectIncomplete(tx *sql.Tx) (*Event, error) {
+	query := `
+	WITH next_incomplete AS (
+		SELECT id FROM outbox
+		WHERE status = 'incomplete'
+		ORDER BY created_at ASC
+		FOR UPDATE SKIP LOCKED
+		LIMIT 1
+	)
+	UPDATE outbox o
+	SET
+		updated_at = NOW(),
+		status = $1
+	FROM next_incomplete
+	WHERE o.id = next_incomplete.id
+	AND o.status = 'incomplete'
+	RETURNING o.id, o.created_at, o.updated_at, o.event_type, o.payload, o.status
+	`
+
+	result := &Event{}
+
+	if err := tx.QueryRow(
+		query,
+		STATUS_PENDING,
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UpdatedAt,
+		&result.EventType,
+		&result.Payload,
+		&result.Status,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// UpdateStalePending returns events claimed more than timeout ago, and never
+// marked completed or incomplete, to incomplete.
+func UpdateStalePending(tx *sql.Tx, timeout time.Duration) (int64, error) {
+	query := `
+	UPDATE outbox
+	SET
+		updated_at = NOW(),
+		status = $1
+	WHERE status = $2
+	AND updated_at < NOW() - make_interval(secs => $3)
+	`
+
+	result, err := tx.Exec(
+		query,
+		STATUS_INCOMPLETE,
+		STATUS_PENDING,
+		timeout.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func UpdateEventStatus(tx *sql.Tx, reqEvent *Event, status EventStatus) (*Event, error) {
+	query := `
+	UPDATE outbox
+	SET
+		updated_at = NOW(),
+		status = $2
+	WHERE id = $1
+	RETURNING id, created_at, updated_at, event_type, payload, status
+	`
+
+	result := &Event{}
+
+	if err := tx.QueryRow(
+		query,
+		reqEvent.Id,
+		status,
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UpdatedAt,
+		&result.EventType,
+		&result.Payload,
+		&result.Status,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
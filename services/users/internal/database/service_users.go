@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"time"
 
@@ -9,24 +10,44 @@ import (
 	"github.com/JustinLi007/whatdoing/services/users/internal/token"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// ErrUserConflict is returned when an update would give a user the email or
+// username of another.
+var ErrUserConflict = errors.New("email or username already taken")
+
 type User struct {
-	Id           uuid.UUID          `json:"id"`
-	CreatedAt    time.Time          `json:"created_at"`
-	UpdatedAt    time.Time          `json:"updated_at"`
-	Email        string             `json:"email"`
-	Password     *password.Password `json:"-"`
-	RefreshToken *token.Token       `json:"-"`
-	Username     *string            `json:"username"`
-	Role         string             `json:"role"`
+	Id        uuid.UUID          `json:"id"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+	Email     string             `json:"email"`
+	Password  *password.Password `json:"-"`
+	Username  *string            `json:"username"`
+	Role      string             `json:"role"`
+	// EmailVerifiedAt is nil until the user proves they own Email.
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
 }
 
 type ServiceUsers interface {
 	CreateUser(reqUser *User) (*User, error)
 	GetUserById(reqUser *User) (*User, error)
 	GetUserByEmailPassword(reqUser *User) (*User, error)
+	CreateSession(reqSession *Session) (*Session, error)
+	GetSessions(reqUser *User) ([]*Session, error)
+	RefreshSession(reqSession *Session) (*Session, error)
+	RevokeSession(reqSession *Session) error
+	RevokeSessions(reqUser *User) error
+	Logout(reqSession *Session, reqToken *RevokedToken) error
+	GetRevokedTokens(since time.Time) ([]*RevokedToken, error)
+	GetRevokedUsers(since time.Time) ([]*RevokedUser, error)
+	CreateEmailVerification(reqUser *User) (*EmailVerification, error)
+	VerifyEmail(reqVerification *EmailVerification) (*User, error)
+	CreatePasswordReset(reqUser *User) (*PasswordReset, error)
+	ResetPassword(reqReset *PasswordReset, reqUser *User) error
 	UpdateUser(reqUser *User) (*User, error)
+	ChangePassword(reqUser *User, newPassword *password.Password) error
+	UpdateUserRole(reqUser *User) (*User, error)
 	DeleteUser(reqUser *User) error
 }
 
@@ -40,14 +61,394 @@ func NewServiceUsers(db ServiceDb) ServiceUsers {
 	if serviceUsersInstance != nil {
 		return serviceUsersInstance
 	}
-	newServiceUsers := &serviceUsers{
-		db: db,
+	newServiceUsers := &serviceUsers{
+		db: db,
+	}
+	serviceUsersInstance = newServiceUsers
+	return serviceUsersInstance
+}
+
+func (s *serviceUsers) CreateUser(reqUser *User) (*User, error) {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := InsertUser(tx, reqUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *serviceUsers) GetUserById(reqUser *User) (*User, error) {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := SelectUserById(tx, reqUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *serviceUsers) GetUserByEmailPassword(reqUser *User) (*User, error) {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := SelectUserByEmailPassword(tx, reqUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// CreateSession starts a session for a signed in device and issues its first
+// refresh token.
+func (s *serviceUsers) CreateSession(reqSession *Session) (*Session, error) {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	reqSession.Token = token.NewToken(token.REFRESH_TOKEN_TTL)
+	result, err := InsertSession(tx, reqSession)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := InsertRefreshToken(tx, &RefreshToken{
+		UserId:    result.UserId,
+		SessionId: result.Id,
+		Token:     result.Token,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *serviceUsers) GetSessions(reqUser *User) ([]*Session, error) {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := SelectSessionsByUserId(tx, reqUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// RefreshSession rotates the session's refresh token presented in reqSession.
+// Presenting an already rotated token revokes the whole session.
+func (s *serviceUsers) RefreshSession(reqSession *Session) (*Session, error) {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	dbToken, err := SelectRefreshTokenByHash(tx, &RefreshToken{
+		Token: reqSession.Token,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if dbToken.RevokedAt != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if dbToken.RotatedAt != nil {
+		err := RevokeSession(tx, &Session{
+			Id:     dbToken.SessionId,
+			UserId: dbToken.UserId,
+		})
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if dbToken.Token.Expired() {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	if err := UpdateRefreshTokenRotated(tx, dbToken); err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := InsertRefreshToken(tx, &RefreshToken{
+		UserId:    dbToken.UserId,
+		SessionId: dbToken.SessionId,
+		Token:     token.NewToken(token.REFRESH_TOKEN_TTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := UpdateSessionRotated(tx, &Session{
+		Id:        dbToken.SessionId,
+		UserAgent: reqSession.UserAgent,
+		Ip:        reqSession.Ip,
+		Token:     refreshToken.Token,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *serviceUsers) RevokeSession(reqSession *Session) error {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	if err := RevokeSession(tx, reqSession); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *serviceUsers) RevokeSessions(reqUser *User) error {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	if err := RevokeSessionsByUserId(tx, reqUser); err != nil {
+		return err
+	}
+
+	if err := InsertRevokedUser(tx, reqUser); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Logout revokes the session the refresh token in reqSession belongs to and
+// denylists the jwt in reqToken. Either may be nil.
+func (s *serviceUsers) Logout(reqSession *Session, reqToken *RevokedToken) error {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	if reqSession != nil {
+		dbToken, err := SelectRefreshTokenByHash(tx, &RefreshToken{
+			Token: reqSession.Token,
+		})
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil {
+			err := RevokeSession(tx, &Session{
+				Id:     dbToken.SessionId,
+				UserId: dbToken.UserId,
+			})
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+	}
+
+	if reqToken != nil {
+		if err := InsertRevokedToken(tx, reqToken); err != nil {
+			return err
+		}
+		if err := DeleteExpiredRevokedTokens(tx); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *serviceUsers) GetRevokedTokens(since time.Time) ([]*RevokedToken, error) {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := SelectRevokedTokens(tx, since)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *serviceUsers) GetRevokedUsers(since time.Time) ([]*RevokedUser, error) {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := SelectRevokedUsers(tx, since)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
-	serviceUsersInstance = newServiceUsers
-	return serviceUsersInstance
+
+	return result, nil
 }
 
-func (s *serviceUsers) CreateUser(reqUser *User) (*User, error) {
+// CreateEmailVerification issues a verification token for the user's current
+// email. The plain text token is only available on the result.
+func (s *serviceUsers) CreateEmailVerification(reqUser *User) (*EmailVerification, error) {
 	tx, err := s.db.Conn().Begin()
 	if err != nil {
 		return nil, err
@@ -61,7 +462,11 @@ func (s *serviceUsers) CreateUser(reqUser *User) (*User, error) {
 		}
 	}()
 
-	result, err := InsertUser(tx, reqUser)
+	result, err := InsertEmailVerification(tx, &EmailVerification{
+		UserId: reqUser.Id,
+		Email:  reqUser.Email,
+		Token:  token.NewToken(token.EMAIL_VERIFICATION_TTL),
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +478,8 @@ func (s *serviceUsers) CreateUser(reqUser *User) (*User, error) {
 	return result, nil
 }
 
-func (s *serviceUsers) GetUserById(reqUser *User) (*User, error) {
+// VerifyEmail consumes the verification token and marks its user verified.
+func (s *serviceUsers) VerifyEmail(reqVerification *EmailVerification) (*User, error) {
 	tx, err := s.db.Conn().Begin()
 	if err != nil {
 		return nil, err
@@ -87,7 +493,27 @@ func (s *serviceUsers) GetUserById(reqUser *User) (*User, error) {
 		}
 	}()
 
-	result, err := SelectUserById(tx, reqUser)
+	dbVerification, err := SelectEmailVerificationByHash(tx, reqVerification)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVerificationTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if dbVerification.UsedAt != nil || dbVerification.Token.Expired() {
+		return nil, ErrVerificationTokenInvalid
+	}
+
+	if err := UpdateEmailVerificationUsed(tx, dbVerification); err != nil {
+		return nil, err
+	}
+
+	// The user changed their email since the token was issued.
+	result, err := UpdateUserEmailVerified(tx, dbVerification)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVerificationTokenInvalid
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +525,10 @@ func (s *serviceUsers) GetUserById(reqUser *User) (*User, error) {
 	return result, nil
 }
 
-func (s *serviceUsers) GetUserByEmailPassword(reqUser *User) (*User, error) {
+// CreatePasswordReset issues a reset token for the user with reqUser's email.
+// It returns sql.ErrNoRows if there is none, and ErrResetThrottled if the
+// user was issued a token less than token.PASSWORD_RESET_INTERVAL ago.
+func (s *serviceUsers) CreatePasswordReset(reqUser *User) (*PasswordReset, error) {
 	tx, err := s.db.Conn().Begin()
 	if err != nil {
 		return nil, err
@@ -113,14 +542,23 @@ func (s *serviceUsers) GetUserByEmailPassword(reqUser *User) (*User, error) {
 		}
 	}()
 
-	result, err := SelectUserByEmailPassword(tx, reqUser)
+	dbUser, err := SelectUserByEmail(tx, reqUser)
 	if err != nil {
 		return nil, err
 	}
 
-	result.RefreshToken = token.NewToken(token.REFRESH_TOKEN_TTL)
+	issued, err := SelectPasswordResetIssuedSince(tx, dbUser, time.Now().Add(-token.PASSWORD_RESET_INTERVAL))
+	if err != nil {
+		return nil, err
+	}
+	if issued {
+		return nil, ErrResetThrottled
+	}
 
-	result, err = UpdateRefreshToken(tx, result)
+	result, err := InsertPasswordReset(tx, &PasswordReset{
+		UserId: dbUser.Id,
+		Token:  token.NewToken(token.PASSWORD_RESET_TTL),
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -132,6 +570,65 @@ func (s *serviceUsers) GetUserByEmailPassword(reqUser *User) (*User, error) {
 	return result, nil
 }
 
+// ResetPassword consumes the reset token and sets the password of its user to
+// reqUser's. Every session and jwt of the user is revoked.
+func (s *serviceUsers) ResetPassword(reqReset *PasswordReset, reqUser *User) error {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	dbReset, err := SelectPasswordResetByHash(tx, reqReset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrResetTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	if dbReset.UsedAt != nil || dbReset.Token.Expired() {
+		return ErrResetTokenInvalid
+	}
+
+	dbUser := &User{
+		Id:       dbReset.UserId,
+		Password: reqUser.Password,
+	}
+
+	if err := UpdatePasswordResetsUsed(tx, dbUser); err != nil {
+		return err
+	}
+
+	if err := UpdateUserPassword(tx, dbUser); err != nil {
+		return err
+	}
+
+	if err := RevokeSessionsByUserId(tx, dbUser); err != nil {
+		return err
+	}
+
+	if err := InsertRevokedUser(tx, dbUser); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateUser sets the username and email of reqUser that are not empty. A new
+// email must be verified again, and the user's jwts are revoked so they are
+// reissued with the reduced scope.
 func (s *serviceUsers) UpdateUser(reqUser *User) (*User, error) {
 	tx, err := s.db.Conn().Begin()
 	if err != nil {
@@ -146,11 +643,102 @@ func (s *serviceUsers) UpdateUser(reqUser *User) (*User, error) {
 		}
 	}()
 
+	dbUser, err := SelectUserById(tx, reqUser)
+	if err != nil {
+		return nil, err
+	}
+
 	result, err := UpdateUser(tx, reqUser)
+	if isUniqueViolation(err) {
+		return nil, ErrUserConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Email != dbUser.Email {
+		if err := InsertRevokedUser(tx, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ChangePassword sets newPassword if reqUser.Password matches the current one,
+// returning sql.ErrNoRows if it does not. Every session and jwt of the user is
+// revoked.
+func (s *serviceUsers) ChangePassword(reqUser *User, newPassword *password.Password) error {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	dbUser, err := SelectUserByIdPassword(tx, reqUser)
+	if err != nil {
+		return err
+	}
+	dbUser.Password = newPassword
+
+	if err := UpdateUserPassword(tx, dbUser); err != nil {
+		return err
+	}
+
+	if err := UpdatePasswordResetsUsed(tx, dbUser); err != nil {
+		return err
+	}
+
+	if err := RevokeSessionsByUserId(tx, dbUser); err != nil {
+		return err
+	}
+
+	if err := InsertRevokedUser(tx, dbUser); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *serviceUsers) UpdateUserRole(reqUser *User) (*User, error) {
+	tx, err := s.db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if err.Error() == "sql: transaction has already been committed or rolled back" {
+				return
+			}
+			log.Printf("error: %v", err)
+		}
+	}()
+
+	result, err := UpdateUserRole(tx, reqUser)
 	if err != nil {
 		return nil, err
 	}
 
+	// Tokens carrying the old scopes must be refreshed.
+	if err := InsertRevokedUser(tx, result); err != nil {
+		return nil, err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
@@ -158,6 +746,9 @@ func (s *serviceUsers) UpdateUser(reqUser *User) (*User, error) {
 	return result, nil
 }
 
+// DeleteUser deletes the user if reqUser.Password matches theirs, returning
+// sql.ErrNoRows if it does not. The user's jwts are revoked and a user.deleted
+// event tells the other services to remove their data.
 func (s *serviceUsers) DeleteUser(reqUser *User) error {
 	tx, err := s.db.Conn().Begin()
 	if err != nil {
@@ -172,7 +763,23 @@ func (s *serviceUsers) DeleteUser(reqUser *User) error {
 		}
 	}()
 
-	if err := DeleteUser(tx, reqUser); err != nil {
+	dbUser, err := SelectUserByIdPassword(tx, reqUser)
+	if err != nil {
+		return err
+	}
+
+	if err := InsertRevokedUser(tx, dbUser); err != nil {
+		return err
+	}
+
+	if err := DeleteUser(tx, dbUser); err != nil {
+		return err
+	}
+
+	if err := InsertEvent(tx, EVENT_USER_DELETED, &UserDeletedEvent{
+		UserId:    dbUser.Id,
+		DeletedAt: time.Now().UTC(),
+	}); err != nil {
 		return err
 	}
 
@@ -185,32 +792,27 @@ func (s *serviceUsers) DeleteUser(reqUser *User) error {
 
 func InsertUser(tx *sql.Tx, reqUser *User) (*User, error) {
 	query := `
-	INSERT INTO users (id, email, password_hash, refresh_token, expiry)
-	VALUES ($1, $2, $3, $4, $5)
+	INSERT INTO users (id, email, password_hash)
+	VALUES ($1, $2, $3)
 	ON CONFLICT DO NOTHING
-	RETURNING id, created_at, updated_at, email, refresh_token, expiry, username, role 
+	RETURNING id, created_at, updated_at, email, username, role, email_verified_at
 	`
 
-	result := &User{
-		RefreshToken: &token.Token{},
-	}
+	result := &User{}
 
 	if err := tx.QueryRow(
 		query,
 		uuid.New(),
 		reqUser.Email,
 		reqUser.Password.Hash,
-		reqUser.RefreshToken.Hash,
-		reqUser.RefreshToken.Expiry,
 	).Scan(
 		&result.Id,
 		&result.CreatedAt,
 		&result.UpdatedAt,
 		&result.Email,
-		&result.RefreshToken.Hash,
-		&result.RefreshToken.Expiry,
 		&result.Username,
 		&result.Role,
+		&result.EmailVerifiedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -220,14 +822,12 @@ func InsertUser(tx *sql.Tx, reqUser *User) (*User, error) {
 
 func SelectUserById(tx *sql.Tx, reqUser *User) (*User, error) {
 	query := `
-	SELECT id, created_at, updated_at, email, refresh_token, expiry, username, role
+	SELECT id, created_at, updated_at, email, username, role, email_verified_at
 	FROM users
 	WHERE id = $1
 	`
 
-	result := &User{
-		RefreshToken: &token.Token{},
-	}
+	result := &User{}
 
 	if err := tx.QueryRow(
 		query,
@@ -237,10 +837,36 @@ func SelectUserById(tx *sql.Tx, reqUser *User) (*User, error) {
 		&result.CreatedAt,
 		&result.UpdatedAt,
 		&result.Email,
-		&result.RefreshToken.Hash,
-		&result.RefreshToken.Expiry,
 		&result.Username,
 		&result.Role,
+		&result.EmailVerifiedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func SelectUserByEmail(tx *sql.Tx, reqUser *User) (*User, error) {
+	query := `
+	SELECT id, created_at, updated_at, email, username, role, email_verified_at
+	FROM users
+	WHERE email = $1
+	`
+
+	result := &User{}
+
+	if err := tx.QueryRow(
+		query,
+		reqUser.Email,
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UpdatedAt,
+		&result.Email,
+		&result.Username,
+		&result.Role,
+		&result.EmailVerifiedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -250,14 +876,13 @@ func SelectUserById(tx *sql.Tx, reqUser *User) (*User, error) {
 
 func SelectUserByEmailPassword(tx *sql.Tx, reqUser *User) (*User, error) {
 	query := `
-	SELECT id, created_at, updated_at, email, password_hash, refresh_token, expiry, username, role
+	SELECT id, created_at, updated_at, email, password_hash, username, role, email_verified_at
 	FROM users
 	WHERE email = $1
 	`
 
 	result := &User{
-		Password:     &password.Password{},
-		RefreshToken: &token.Token{},
+		Password: &password.Password{},
 	}
 
 	if err := tx.QueryRow(
@@ -269,10 +894,49 @@ func SelectUserByEmailPassword(tx *sql.Tx, reqUser *User) (*User, error) {
 		&result.UpdatedAt,
 		&result.Email,
 		&result.Password.Hash,
-		&result.RefreshToken.Hash,
-		&result.RefreshToken.Expiry,
 		&result.Username,
 		&result.Role,
+		&result.EmailVerifiedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	match, err := result.Password.Validate(reqUser.Password.PlainText)
+	if err != nil {
+		return nil, err
+	}
+
+	if !match {
+		return nil, sql.ErrNoRows
+	}
+
+	return result, nil
+}
+
+func SelectUserByIdPassword(tx *sql.Tx, reqUser *User) (*User, error) {
+	query := `
+	SELECT id, created_at, updated_at, email, password_hash, username, role, email_verified_at
+	FROM users
+	WHERE id = $1
+	FOR UPDATE
+	`
+
+	result := &User{
+		Password: &password.Password{},
+	}
+
+	if err := tx.QueryRow(
+		query,
+		reqUser.Id,
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.UpdatedAt,
+		&result.Email,
+		&result.Password.Hash,
+		&result.Username,
+		&result.Role,
+		&result.EmailVerifiedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -290,23 +954,27 @@ func SelectUserByEmailPassword(tx *sql.Tx, reqUser *User) (*User, error) {
 }
 
 func UpdateUser(tx *sql.Tx, reqUser *User) (*User, error) {
-	// TODO:
 	query := `
 	UPDATE users
 	SET
-		updated_at = $1,
-		username = $2
-	WHERE id = $3
-	RETURNING id, created_at, updated_at, username, email, role
+		updated_at = NOW(),
+		username = COALESCE($2, username),
+		email = COALESCE(NULLIF($3, ''), email),
+		email_verified_at = CASE
+			WHEN NULLIF($3, '') IS NULL OR $3 = email THEN email_verified_at
+			ELSE NULL
+		END
+	WHERE id = $1
+	RETURNING id, created_at, updated_at, username, email, role, email_verified_at
 	`
 
 	result := &User{}
 
 	if err := tx.QueryRow(
 		query,
-		time.Now().UTC(),
-		reqUser.Username,
 		reqUser.Id,
+		reqUser.Username,
+		reqUser.Email,
 	).Scan(
 		&result.Id,
 		&result.CreatedAt,
@@ -314,6 +982,7 @@ func UpdateUser(tx *sql.Tx, reqUser *User) (*User, error) {
 		&result.Username,
 		&result.Email,
 		&result.Role,
+		&result.EmailVerifiedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -321,33 +990,59 @@ func UpdateUser(tx *sql.Tx, reqUser *User) (*User, error) {
 	return result, nil
 }
 
-func UpdateRefreshToken(tx *sql.Tx, reqUser *User) (*User, error) {
+func UpdateUserPassword(tx *sql.Tx, reqUser *User) error {
 	query := `
 	UPDATE users
 	SET
 		updated_at = NOW(),
-		refresh_token = $2
+		password_hash = $2
 	WHERE id = $1
-	RETURNING id, created_at, updated_at, email, refresh_token, expiry, username, role
 	`
 
-	result := &User{
-		RefreshToken: &token.Token{},
+	queryResult, err := tx.Exec(
+		query,
+		reqUser.Id,
+		reqUser.Password.Hash,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := queryResult.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
 	}
 
+	return nil
+}
+
+func UpdateUserRole(tx *sql.Tx, reqUser *User) (*User, error) {
+	query := `
+	UPDATE users
+	SET
+		updated_at = NOW(),
+		role = $2
+	WHERE id = $1
+	RETURNING id, created_at, updated_at, username, email, role, email_verified_at
+	`
+
+	result := &User{}
+
 	if err := tx.QueryRow(
 		query,
 		reqUser.Id,
-		reqUser.RefreshToken.Hash,
+		reqUser.Role,
 	).Scan(
 		&result.Id,
 		&result.CreatedAt,
 		&result.UpdatedAt,
-		&result.Email,
-		&result.RefreshToken.Hash,
-		&result.RefreshToken.Expiry,
 		&result.Username,
+		&result.Email,
 		&result.Role,
+		&result.EmailVerifiedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -379,3 +1074,8 @@ func DeleteUser(tx *sql.Tx, reqUser *User) error {
 
 	return nil
 }
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,228 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/services/users/internal/token"
+
+	"github.com/google/uuid"
+)
+
+// Session is one signed in device. Its hash is the one of the session's
+// current refresh token.
+type Session struct {
+	Id         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+	UserId     uuid.UUID  `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	Ip         string     `json:"ip"`
+	// Token only carries the plain text right after it was issued.
+	Token   *token.Token `json:"-"`
+	Current bool         `json:"current"`
+}
+
+func InsertSession(tx *sql.Tx, reqSession *Session) (*Session, error) {
+	query := `
+	INSERT INTO sessions (id, expires_at, user_id, hash, user_agent, ip)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at, last_used_at, expires_at, user_id, hash, user_agent, ip
+	`
+
+	result := &Session{
+		Token: &token.Token{
+			PlainText: reqSession.Token.PlainText,
+			Expiry:    reqSession.Token.Expiry,
+		},
+	}
+
+	if err := tx.QueryRow(
+		query,
+		uuid.New(),
+		reqSession.Token.Expiry,
+		reqSession.UserId,
+		reqSession.Token.GetHash(),
+		reqSession.UserAgent,
+		reqSession.Ip,
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.LastUsedAt,
+		&result.ExpiresAt,
+		&result.UserId,
+		&result.Token.Hash,
+		&result.UserAgent,
+		&result.Ip,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func SelectSessionsByUserId(tx *sql.Tx, reqUser *User) ([]*Session, error) {
+	query := `
+	SELECT id, created_at, last_used_at, expires_at, user_id, hash, user_agent, ip
+	FROM sessions
+	WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+	ORDER BY last_used_at DESC
+	`
+
+	rows, err := tx.Query(
+		query,
+		reqUser.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*Session, 0)
+	for rows.Next() {
+		session := &Session{
+			Token: &token.Token{},
+		}
+		if err := rows.Scan(
+			&session.Id,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+			&session.UserId,
+			&session.Token.Hash,
+			&session.UserAgent,
+			&session.Ip,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// UpdateSessionRotated points the session at its newly rotated refresh token.
+func UpdateSessionRotated(tx *sql.Tx, reqSession *Session) (*Session, error) {
+	query := `
+	UPDATE sessions
+	SET
+		last_used_at = NOW(),
+		expires_at = $2,
+		hash = $3,
+		user_agent = $4,
+		ip = $5
+	WHERE id = $1 AND revoked_at IS NULL
+	RETURNING id, created_at, last_used_at, expires_at, user_id, hash, user_agent, ip
+	`
+
+	result := &Session{
+		Token: &token.Token{
+			PlainText: reqSession.Token.PlainText,
+			Expiry:    reqSession.Token.Expiry,
+		},
+	}
+
+	if err := tx.QueryRow(
+		query,
+		reqSession.Id,
+		reqSession.Token.Expiry,
+		reqSession.Token.GetHash(),
+		reqSession.UserAgent,
+		reqSession.Ip,
+	).Scan(
+		&result.Id,
+		&result.CreatedAt,
+		&result.LastUsedAt,
+		&result.ExpiresAt,
+		&result.UserId,
+		&result.Token.Hash,
+		&result.UserAgent,
+		&result.Ip,
+	); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// RevokeSession revokes one of the user's sessions along with its refresh
+// tokens, and denylists the session id so its jwts are rejected too.
+func RevokeSession(tx *sql.Tx, reqSession *Session) error {
+	query := `
+	UPDATE sessions
+	SET revoked_at = NOW()
+	WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	queryResult, err := tx.Exec(
+		query,
+		reqSession.Id,
+		reqSession.UserId,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := queryResult.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	query = `
+	UPDATE refresh_tokens
+	SET revoked_at = NOW()
+	WHERE session_id = $1 AND revoked_at IS NULL
+	`
+
+	if _, err := tx.Exec(
+		query,
+		reqSession.Id,
+	); err != nil {
+		return err
+	}
+
+	return InsertRevokedToken(tx, &RevokedToken{
+		Jti:       reqSession.Id.String(),
+		ExpiresAt: time.Now().Add(token.JWT_TTL).UTC(),
+	})
+}
+
+// RevokeSessionsByUserId revokes every session of the user along with their
+// refresh tokens.
+func RevokeSessionsByUserId(tx *sql.Tx, reqUser *User) error {
+	query := `
+	UPDATE sessions
+	SET revoked_at = NOW()
+	WHERE user_id = $1 AND revoked_at IS NULL
+	`
+
+	if _, err := tx.Exec(
+		query,
+		reqUser.Id,
+	); err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE refresh_tokens
+	SET revoked_at = NOW()
+	WHERE user_id = $1 AND revoked_at IS NULL
+	`
+
+	if _, err := tx.Exec(
+		query,
+		reqUser.Id,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
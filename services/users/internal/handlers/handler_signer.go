@@ -2,13 +2,18 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 
+	libutils "github.com/JustinLi007/whatdoing/libs/go/util"
 	"github.com/JustinLi007/whatdoing/services/users/internal/signer"
 )
 
 type HandlerSigner interface {
 	GetJwks(w http.ResponseWriter, r *http.Request)
+	GetKeys(w http.ResponseWriter, r *http.Request)
+	RotateKeys(w http.ResponseWriter, r *http.Request)
 }
 
 type handlerSigner struct {
@@ -45,3 +50,31 @@ func (h *handlerSigner) GetJwks(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(js)
 }
+
+func (h *handlerSigner) GetKeys(w http.ResponseWriter, r *http.Request) {
+	libutils.WriteJson(w, http.StatusOK, libutils.Envelope{
+		"keys": h.signer.Keys(),
+	})
+}
+
+// RotateKeys switches signing to a new key. Tokens signed with the previous
+// key keep verifying until they expire.
+func (h *handlerSigner) RotateKeys(w http.ResponseWriter, r *http.Request) {
+	kid, err := h.signer.Rotate()
+	if errors.Is(err, signer.ErrRotationInProgress) {
+		libutils.WriteJson(w, http.StatusConflict, libutils.Envelope{
+			"message": "key rotation in progress",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	libutils.WriteJson(w, http.StatusOK, libutils.Envelope{
+		"kid":  kid,
+		"keys": h.signer.Keys(),
+	})
+}
@@ -1,16 +1,28 @@
 package handlers
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	libutils "github.com/JustinLi007/whatdoing/libs/go/util"
 	"github.com/JustinLi007/whatdoing/services/users/internal/database"
+	"github.com/JustinLi007/whatdoing/services/users/internal/mailer"
 	"github.com/JustinLi007/whatdoing/services/users/internal/password"
+	"github.com/JustinLi007/whatdoing/services/users/internal/role"
 	"github.com/JustinLi007/whatdoing/services/users/internal/signer"
 	"github.com/JustinLi007/whatdoing/services/users/internal/token"
 	"github.com/JustinLi007/whatdoing/services/users/internal/utils"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 type HandlerUsers interface {
@@ -18,22 +30,53 @@ type HandlerUsers interface {
 	Login(w http.ResponseWriter, r *http.Request)
 	Logout(w http.ResponseWriter, r *http.Request)
 	Refresh(w http.ResponseWriter, r *http.Request)
+	GetSessions(w http.ResponseWriter, r *http.Request)
+	RevokeSession(w http.ResponseWriter, r *http.Request)
+	RevokeSessions(w http.ResponseWriter, r *http.Request)
+	GetRevokedTokens(w http.ResponseWriter, r *http.Request)
+	VerifyEmail(w http.ResponseWriter, r *http.Request)
+	ResendVerification(w http.ResponseWriter, r *http.Request)
+	ForgotPassword(w http.ResponseWriter, r *http.Request)
+	ResetPassword(w http.ResponseWriter, r *http.Request)
+	GetMe(w http.ResponseWriter, r *http.Request)
+	UpdateMe(w http.ResponseWriter, r *http.Request)
+	ChangePassword(w http.ResponseWriter, r *http.Request)
+	DeleteMe(w http.ResponseWriter, r *http.Request)
+	UpdateRole(w http.ResponseWriter, r *http.Request)
 }
 
+// Password reset emails are sent in the background by a fixed number of
+// workers. Requests beyond the queue are dropped.
+const (
+	PASSWORD_RESET_WORKERS    = 4
+	PASSWORD_RESET_QUEUE_SIZE = 256
+)
+
 type handlerUsers struct {
 	signer      signer.Signer
 	userService database.ServiceUsers
+	mailer      mailer.Mailer
+	appUrl      string
+	// resets queues the emails to send a password reset to.
+	resets chan string
 }
 
 var handlerUsersInstance *handlerUsers
 
-func NewHandlerUsers(signer signer.Signer, userService database.ServiceUsers) HandlerUsers {
+// NewHandlerUsers takes the url of the web app, links in emails point to it.
+func NewHandlerUsers(signer signer.Signer, userService database.ServiceUsers, mailer mailer.Mailer, appUrl string) HandlerUsers {
 	if handlerUsersInstance != nil {
 		return handlerUsersInstance
 	}
 	newHandlerUsers := &handlerUsers{
 		signer:      signer,
 		userService: userService,
+		mailer:      mailer,
+		appUrl:      strings.TrimSuffix(appUrl, "/"),
+		resets:      make(chan string, PASSWORD_RESET_QUEUE_SIZE),
+	}
+	for range PASSWORD_RESET_WORKERS {
+		go newHandlerUsers.sendPasswordResets()
 	}
 	handlerUsersInstance = newHandlerUsers
 	return handlerUsersInstance
@@ -62,26 +105,35 @@ func (h *handlerUsers) SignUp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	reqUser := &database.User{
-		Email:        req.Email,
-		Password:     &password.Password{},
-		RefreshToken: token.NewToken(token.REFRESH_TOKEN_TTL),
+		Email:    req.Email,
+		Password: &password.Password{},
 	}
 	reqUser.Password.Set(req.Password)
 
 	dbUser, err := h.userService.CreateUser(reqUser)
+	if errors.Is(err, sql.ErrNoRows) {
+		libutils.WriteJson(w, http.StatusConflict, libutils.Envelope{
+			"message": "email already registered",
+		})
+		return
+	}
 	if err != nil {
 		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
 		return
 	}
 
-	jwt, err := h.signer.NewJwt(dbUser.Id.String(), "", time.Hour)
-	if err != nil {
+	if err := h.startSession(w, r, dbUser); err != nil {
+		log.Printf("error: %v", err)
 		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
 		return
 	}
 
-	libutils.SetCookie(w, "jwt", jwt)
-	libutils.SetCookie(w, "refresh-token", dbUser.RefreshToken.GetPlainText())
+	// The account works with a reduced scope until verified, the user can ask
+	// for another email if this one fails.
+	if err := h.sendVerification(dbUser); err != nil {
+		log.Printf("error: send verification email: %v", err)
+	}
+
 	libutils.WriteJson(w, http.StatusCreated, libutils.Envelope{
 		"user": dbUser,
 	})
@@ -115,34 +167,742 @@ func (h *handlerUsers) Login(w http.ResponseWriter, r *http.Request) {
 	}
 	reqUser.Password.Set(req.Password)
 
-	//TODO: user should have scope?
 	dbUser, err := h.userService.GetUserByEmailPassword(reqUser)
+	if errors.Is(err, sql.ErrNoRows) {
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{
+			"message": "invalid email or password",
+		})
+		return
+	}
 	if err != nil {
 		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
 		return
 	}
 
-	jwt, err := h.signer.NewJwt(dbUser.Id.String(), "ohfk,", time.Hour)
-	if err != nil {
+	if err := h.startSession(w, r, dbUser); err != nil {
+		log.Printf("error: %v", err)
 		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
 		return
 	}
 
-	libutils.SetCookie(w, "jwt", jwt)
-	libutils.SetCookie(w, "refresh-token", dbUser.RefreshToken.GetPlainText())
 	libutils.WriteJson(w, http.StatusOK, libutils.Envelope{
 		"user": dbUser,
 	})
 }
 
+// Logout revokes the caller's session and denylists their jwt until it
+// expires. It succeeds even without valid tokens so clients can always clear
+// their cookies.
 func (h *handlerUsers) Logout(w http.ResponseWriter, r *http.Request) {
-	libutils.WriteJson(w, http.StatusNotImplemented, libutils.Envelope{
-		"message": "logout not yet implemented",
-	})
+	var reqSession *database.Session
+	if refreshCookie, err := r.Cookie("refresh-token"); err == nil && refreshCookie.Value != "" {
+		reqSession = &database.Session{
+			Token: &token.Token{
+				PlainText: refreshCookie.Value,
+			},
+		}
+	}
+
+	var reqToken *database.RevokedToken
+	if tokenStr := jwtFromRequest(r); tokenStr != "" {
+		parsedJwt, err := h.signer.ParseJwt(tokenStr)
+		if err == nil {
+			jti, hasJti := parsedJwt.JwtID()
+			exp, hasExp := parsedJwt.Expiration()
+			if hasJti && hasExp && time.Now().Before(exp) {
+				reqToken = &database.RevokedToken{
+					Jti:       jti,
+					ExpiresAt: exp,
+				}
+			}
+		}
+	}
+
+	if err := h.userService.Logout(reqSession, reqToken); err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	libutils.DeleteCookie(w, "jwt")
+	libutils.DeleteCookie(w, "refresh-token")
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *handlerUsers) Refresh(w http.ResponseWriter, r *http.Request) {
-	libutils.WriteJson(w, http.StatusNotImplemented, libutils.Envelope{
-		"message": "refresh not yet implemented",
+	refreshCookie, err := r.Cookie("refresh-token")
+	if err != nil || refreshCookie.Value == "" {
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{})
+		return
+	}
+
+	dbSession, err := h.userService.RefreshSession(&database.Session{
+		UserAgent: r.UserAgent(),
+		Ip:        utils.ClientIp(r),
+		Token: &token.Token{
+			PlainText: refreshCookie.Value,
+		},
+	})
+	if errors.Is(err, database.ErrRefreshTokenInvalid) ||
+		errors.Is(err, database.ErrRefreshTokenExpired) ||
+		errors.Is(err, database.ErrRefreshTokenReused) {
+		if errors.Is(err, database.ErrRefreshTokenReused) {
+			log.Printf("warning: refresh token reused, session revoked")
+		}
+		libutils.DeleteCookie(w, "jwt")
+		libutils.DeleteCookie(w, "refresh-token")
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{})
+		return
+	}
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	dbUser, err := h.userService.GetUserById(&database.User{
+		Id: dbSession.UserId,
+	})
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	if err := h.setTokens(w, dbUser, dbSession); err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	libutils.WriteJson(w, http.StatusOK, libutils.Envelope{
+		"user": dbUser,
+	})
+}
+
+// UpdateRole changes a user's role. Tokens issued before the change are
+// revoked so the user has to refresh them to get the new scopes.
+func (h *handlerUsers) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	type UpdateRoleRequest struct {
+		Role string `json:"role"`
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	var req UpdateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	if !role.Valid(req.Role) {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{
+			"message": "unknown role",
+		})
+		return
+	}
+
+	reqUser := &database.User{
+		Id:   id,
+		Role: req.Role,
+	}
+	dbUser, err := h.userService.UpdateUserRole(reqUser)
+	if errors.Is(err, sql.ErrNoRows) {
+		libutils.WriteJson(w, http.StatusNotFound, libutils.Envelope{})
+		return
+	}
+	if err != nil {
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	libutils.WriteJson(w, http.StatusOK, libutils.Envelope{
+		"user": dbUser,
+	})
+}
+
+func (h *handlerUsers) GetMe(w http.ResponseWriter, r *http.Request) {
+	userId, ok := userIdFromRequest(r)
+	if !ok {
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{})
+		return
+	}
+
+	dbUser, err := h.userService.GetUserById(&database.User{
+		Id: userId,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		libutils.WriteJson(w, http.StatusNotFound, libutils.Envelope{})
+		return
+	}
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	libutils.WriteJson(w, http.StatusOK, libutils.Envelope{
+		"user": dbUser,
+	})
+}
+
+// UpdateMe changes the caller's username and email. A new email is sent a
+// verification link and the caller's jwt stops working, refreshing it issues
+// one with the reduced scope.
+func (h *handlerUsers) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	type UpdateMeRequest struct {
+		Username *string `json:"username"`
+		Email    *string `json:"email"`
+	}
+
+	userId, ok := userIdFromRequest(r)
+	if !ok {
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{})
+		return
+	}
+
+	var req UpdateMeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	reqUser := &database.User{
+		Id:       userId,
+		Username: req.Username,
+	}
+	if req.Username != nil && !utils.IsValidUsername(*req.Username) {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{
+			"message": "invalid username",
+		})
+		return
+	}
+	if req.Email != nil {
+		if !utils.IsValidEmail(*req.Email) {
+			libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{
+				"message": "invalid email",
+			})
+			return
+		}
+		reqUser.Email = *req.Email
+	}
+
+	dbUser, err := h.userService.UpdateUser(reqUser)
+	if errors.Is(err, sql.ErrNoRows) {
+		libutils.WriteJson(w, http.StatusNotFound, libutils.Envelope{})
+		return
+	}
+	if errors.Is(err, database.ErrUserConflict) {
+		libutils.WriteJson(w, http.StatusConflict, libutils.Envelope{
+			"message": "email or username already taken",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	if req.Email != nil && dbUser.EmailVerifiedAt == nil {
+		if err := h.sendVerification(dbUser); err != nil {
+			log.Printf("error: send verification email: %v", err)
+		}
+	}
+
+	libutils.WriteJson(w, http.StatusOK, libutils.Envelope{
+		"user": dbUser,
+	})
+}
+
+// ChangePassword sets a new password after checking the current one. Every
+// session is signed out and the caller is given a new one.
+func (h *handlerUsers) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	type ChangePasswordRequest struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	userId, ok := userIdFromRequest(r)
+	if !ok {
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	if !utils.IsValidPassword(req.NewPassword) {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	newPassword := &password.Password{}
+	if err := newPassword.Set(req.NewPassword); err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	reqUser := &database.User{
+		Id: userId,
+		Password: &password.Password{
+			PlainText: req.CurrentPassword,
+		},
+	}
+	err := h.userService.ChangePassword(reqUser, newPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		libutils.WriteJson(w, http.StatusForbidden, libutils.Envelope{
+			"message": "current password is incorrect",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	dbUser, err := h.userService.GetUserById(reqUser)
+	if err == nil {
+		err = h.startSession(w, r, dbUser)
+	}
+	if err != nil {
+		// The password changed, the caller signs in again.
+		log.Printf("error: %v", err)
+		libutils.DeleteCookie(w, "jwt")
+		libutils.DeleteCookie(w, "refresh-token")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteMe deletes the caller's account after checking their password.
+func (h *handlerUsers) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	type DeleteMeRequest struct {
+		Password string `json:"password"`
+	}
+
+	userId, ok := userIdFromRequest(r)
+	if !ok {
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{})
+		return
+	}
+
+	var req DeleteMeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	err := h.userService.DeleteUser(&database.User{
+		Id: userId,
+		Password: &password.Password{
+			PlainText: req.Password,
+		},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		libutils.WriteJson(w, http.StatusForbidden, libutils.Envelope{
+			"message": "password is incorrect",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	libutils.DeleteCookie(w, "jwt")
+	libutils.DeleteCookie(w, "refresh-token")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSessions lists the caller's active sessions, marking the one making the
+// request.
+func (h *handlerUsers) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userId, ok := userIdFromRequest(r)
+	if !ok {
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{})
+		return
+	}
+
+	dbSessions, err := h.userService.GetSessions(&database.User{
+		Id: userId,
+	})
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	if refreshCookie, err := r.Cookie("refresh-token"); err == nil && refreshCookie.Value != "" {
+		hash := token.Hash(refreshCookie.Value)
+		for _, session := range dbSessions {
+			session.Current = bytes.Equal(session.Token.Hash, hash)
+		}
+	}
+
+	libutils.WriteJson(w, http.StatusOK, libutils.Envelope{
+		"sessions": dbSessions,
+	})
+}
+
+// RevokeSession signs out one of the caller's devices, its jwt is rejected
+// from then on.
+func (h *handlerUsers) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userId, ok := userIdFromRequest(r)
+	if !ok {
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{})
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	err = h.userService.RevokeSession(&database.Session{
+		Id:     id,
+		UserId: userId,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		libutils.WriteJson(w, http.StatusNotFound, libutils.Envelope{})
+		return
+	}
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeSessions signs out all of the caller's devices, including this one.
+func (h *handlerUsers) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	userId, ok := userIdFromRequest(r)
+	if !ok {
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{})
+		return
+	}
+
+	err := h.userService.RevokeSessions(&database.User{
+		Id: userId,
+	})
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	libutils.DeleteCookie(w, "jwt")
+	libutils.DeleteCookie(w, "refresh-token")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetRevokedTokens lists the unexpired jwt and user revocations made after the
+// "since" query parameter (RFC 3339). The gateway polls it on the internal
+// listener, which it never proxies to.
+func (h *handlerUsers) GetRevokedTokens(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{
+				"message": "since must be an RFC 3339 timestamp",
+			})
+			return
+		}
+		since = parsed
+	}
+
+	dbTokens, err := h.userService.GetRevokedTokens(since)
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	dbUsers, err := h.userService.GetRevokedUsers(since)
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	libutils.WriteJson(w, http.StatusOK, libutils.Envelope{
+		"revoked_tokens": dbTokens,
+		"revoked_users":  dbUsers,
+	})
+}
+
+// VerifyEmail consumes the token from a verification email. The caller's jwt
+// keeps its reduced scope until it is refreshed.
+func (h *handlerUsers) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type VerifyEmailRequest struct {
+		Token string `json:"token"`
+	}
+
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	dbUser, err := h.userService.VerifyEmail(&database.EmailVerification{
+		Token: &token.Token{
+			PlainText: req.Token,
+		},
+	})
+	if errors.Is(err, database.ErrVerificationTokenInvalid) {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{
+			"message": "invalid or expired token",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	libutils.WriteJson(w, http.StatusOK, libutils.Envelope{
+		"user": dbUser,
+	})
+}
+
+// ResendVerification sends a new verification email to the caller, the
+// previous tokens stop working.
+func (h *handlerUsers) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userId, ok := userIdFromRequest(r)
+	if !ok {
+		libutils.WriteJson(w, http.StatusUnauthorized, libutils.Envelope{})
+		return
+	}
+
+	dbUser, err := h.userService.GetUserById(&database.User{
+		Id: userId,
 	})
+	if errors.Is(err, sql.ErrNoRows) {
+		libutils.WriteJson(w, http.StatusNotFound, libutils.Envelope{})
+		return
+	}
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	if dbUser.EmailVerifiedAt != nil {
+		libutils.WriteJson(w, http.StatusConflict, libutils.Envelope{
+			"message": "email already verified",
+		})
+		return
+	}
+
+	if err := h.sendVerification(dbUser); err != nil {
+		log.Printf("error: send verification email: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForgotPassword emails a reset link if an account has the email. The response
+// is the same, and sent before any lookup, whether or not one does.
+func (h *handlerUsers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	type ForgotPasswordRequest struct {
+		Email string `json:"email"`
+	}
+
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	if !utils.IsValidEmail(req.Email) {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	select {
+	case h.resets <- req.Email:
+	default:
+		log.Printf("error: password reset queue full, dropping request")
+	}
+
+	libutils.WriteJson(w, http.StatusAccepted, libutils.Envelope{
+		"message": "if an account uses this email, a reset link was sent to it",
+	})
+}
+
+// ResetPassword sets a new password with the token from a reset email and
+// signs the user out everywhere.
+func (h *handlerUsers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	type ResetPasswordRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	if !utils.IsValidPassword(req.Password) {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{})
+		return
+	}
+
+	reqUser := &database.User{
+		Password: &password.Password{},
+	}
+	if err := reqUser.Password.Set(req.Password); err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	err := h.userService.ResetPassword(&database.PasswordReset{
+		Token: &token.Token{
+			PlainText: req.Token,
+		},
+	}, reqUser)
+	if errors.Is(err, database.ErrResetTokenInvalid) {
+		libutils.WriteJson(w, http.StatusBadRequest, libutils.Envelope{
+			"message": "invalid or expired token",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("error: %v", err)
+		libutils.WriteJson(w, http.StatusInternalServerError, libutils.Envelope{})
+		return
+	}
+
+	// The refresh token cookie belongs to a revoked session now.
+	libutils.DeleteCookie(w, "jwt")
+	libutils.DeleteCookie(w, "refresh-token")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handlerUsers) sendPasswordResets() {
+	for email := range h.resets {
+		if err := h.sendPasswordReset(email); err != nil {
+			log.Printf("error: send password reset email: %v", err)
+		}
+	}
+}
+
+// sendPasswordReset does nothing if no account uses the email, or if a reset
+// was sent to it recently so that others cannot keep replacing its token.
+func (h *handlerUsers) sendPasswordReset(email string) error {
+	dbReset, err := h.userService.CreatePasswordReset(&database.User{
+		Email: email,
+	})
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, database.ErrResetThrottled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", h.appUrl, url.QueryEscape(dbReset.Token.GetPlainText()))
+	return h.mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Open the link below to choose a new password for whatdoing. It expires in %v.\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			token.PASSWORD_RESET_TTL,
+			link,
+		),
+	})
+}
+
+func (h *handlerUsers) sendVerification(dbUser *database.User) error {
+	dbVerification, err := h.userService.CreateEmailVerification(dbUser)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", h.appUrl, url.QueryEscape(dbVerification.Token.GetPlainText()))
+	return h.mailer.Send(mailer.Message{
+		To:      dbVerification.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Open the link below to verify your email for whatdoing. It expires in %v.\n\n%s\n",
+			token.EMAIL_VERIFICATION_TTL,
+			link,
+		),
+	})
+}
+
+// startSession creates a session for the device making the request and sets
+// its tokens.
+func (h *handlerUsers) startSession(w http.ResponseWriter, r *http.Request, dbUser *database.User) error {
+	dbSession, err := h.userService.CreateSession(&database.Session{
+		UserId:    dbUser.Id,
+		UserAgent: r.UserAgent(),
+		Ip:        utils.ClientIp(r),
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.setTokens(w, dbUser, dbSession)
+}
+
+func (h *handlerUsers) setTokens(w http.ResponseWriter, dbUser *database.User, dbSession *database.Session) error {
+	scope := role.Scope(dbUser.Role)
+	if dbUser.EmailVerifiedAt == nil {
+		scope = role.UnverifiedScope()
+	}
+
+	jwt, err := h.signer.NewJwt(dbUser.Id.String(), dbSession.Id.String(), dbUser.Role, scope, token.JWT_TTL)
+	if err != nil {
+		return err
+	}
+
+	libutils.SetCookie(w, "jwt", jwt)
+	libutils.SetCookie(w, "refresh-token", dbSession.Token.GetPlainText())
+
+	return nil
+}
+
+// jwtFromRequest reads the jwt the same way the gateway does, the bearer
+// header taking precedence over the jwt cookie.
+func jwtFromRequest(r *http.Request) string {
+	scheme, tokenStr, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(tokenStr)
+	}
+
+	if jwtCookie, err := r.Cookie("jwt"); err == nil {
+		return jwtCookie.Value
+	}
+
+	return ""
+}
+
+// userIdFromRequest reads the user id the gateway set after verifying the
+// caller's token.
+func userIdFromRequest(r *http.Request) (uuid.UUID, bool) {
+	userId, err := uuid.Parse(r.Header.Get("Whatdoing-User-Id"))
+	if err != nil {
+		return uuid.UUID{}, false
+	}
+	return userId, true
 }
@@ -0,0 +1,123 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JustinLi007/whatdoing/libs/go/config"
+)
+
+const (
+	MAILER_SMTP = "smtp"
+	MAILER_LOG  = "log"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailerFromConfig builds the mailer selected by MAILER, "log" by default.
+// The log mailer appends to MAILER_FILE, or writes to stdout.
+func NewMailerFromConfig(c *config.Config) (Mailer, error) {
+	switch kind := c.Get("MAILER"); kind {
+	case MAILER_SMTP:
+		port, err := strconv.Atoi(c.Get("SMTP_PORT"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT: %v", err)
+		}
+		if c.Get("SMTP_HOST") == "" || c.Get("SMTP_FROM") == "" {
+			return nil, fmt.Errorf("SMTP_HOST and SMTP_FROM must be set")
+		}
+		return NewSmtpMailer(
+			c.Get("SMTP_HOST"),
+			port,
+			c.Get("SMTP_USERNAME"),
+			c.Get("SMTP_PASSWORD"),
+			c.Get("SMTP_FROM"),
+		), nil
+	case "", MAILER_LOG:
+		if path := c.Get("MAILER_FILE"); path != "" {
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				return nil, err
+			}
+			return NewLogMailer(f), nil
+		}
+		return NewLogMailer(os.Stdout), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", kind)
+	}
+}
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSmtpMailer(host string, port int, username, password, from string) Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpMailer{
+		addr: fmt.Sprintf("%s:%d", host, port),
+		auth: auth,
+		from: from,
+	}
+}
+
+func (m *smtpMailer) Send(msg Message) error {
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, buildMessage(m.from, msg))
+}
+
+// logMailer writes messages instead of sending them, for local development.
+type logMailer struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+func NewLogMailer(w io.Writer) Mailer {
+	return &logMailer{
+		mtx: sync.Mutex{},
+		w:   w,
+	}
+}
+
+func (m *logMailer) Send(msg Message) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "%s\n%s\n", time.Now().UTC().Format(time.RFC3339), buildMessage("whatdoing", msg))
+	return err
+}
+
+func buildMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + sanitizeHeader(from) + "\r\n")
+	b.WriteString("To: " + sanitizeHeader(msg.To) + "\r\n")
+	b.WriteString("Subject: " + sanitizeHeader(msg.Subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// sanitizeHeader keeps user input from injecting extra headers.
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf)
+
+	require.NoError(t, m.Send(Message{
+		To:      "a@b.c",
+		Subject: "Verify your email",
+		Body:    "line one\nline two",
+	}))
+
+	assert.Contains(t, buf.String(), "To: a@b.c\r\n")
+	assert.Contains(t, buf.String(), "Subject: Verify your email\r\n")
+	assert.Contains(t, buf.String(), "line one\r\nline two")
+}
+
+func TestBuildMessageStripsHeaderInjection(t *testing.T) {
+	msg := string(buildMessage("from@b.c", Message{
+		To:      "a@b.c\r\nBcc: evil@b.c",
+		Subject: "hi",
+	}))
+
+	assert.NotContains(t, msg, "\r\nBcc:")
+}
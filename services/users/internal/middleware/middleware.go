@@ -2,46 +2,66 @@ package middleware
 
 import (
 	"net/http"
+
+	"github.com/JustinLi007/whatdoing/libs/go/cors"
+	"github.com/JustinLi007/whatdoing/libs/go/util"
 )
 
 type Middleware interface {
 	Cors(next http.Handler) http.Handler
+	RequireUser(next http.Handler) http.Handler
+	RequireScope(scope string) func(next http.Handler) http.Handler
 }
 
 type middleware struct {
+	corsPolicy *cors.Policy
 }
 
 var middlewareInstance *middleware
 
-var allowedOrigins = map[string]bool{
-	"http://localhost:5173": true,
-}
-
-func NewMiddleware() Middleware {
+func NewMiddleware(corsPolicy *cors.Policy) Middleware {
 	if middlewareInstance != nil {
 		return middlewareInstance
 	}
-	newMiddleware := &middleware{}
+	newMiddleware := &middleware{
+		corsPolicy: corsPolicy,
+	}
 	middlewareInstance = newMiddleware
 	return middlewareInstance
 }
 
 func (m *middleware) Cors(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		if allowedOrigins[origin] {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	return m.corsPolicy.Handler(next)
+}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
+// RequireUser rejects requests the gateway did not verify a token for.
+func (m *middleware) RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Whatdoing-User-Id") == "" {
+			util.WriteJson(w, http.StatusUnauthorized, util.Envelope{})
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireScope relies on the identity headers set by the gateway after it
+// verified the caller's token. The gateway strips them from client requests.
+func (m *middleware) RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Whatdoing-User-Id") == "" {
+				util.WriteJson(w, http.StatusUnauthorized, util.Envelope{})
+				return
+			}
+
+			if !util.HasScope(scope, r.Header.Get("Whatdoing-Scope")) {
+				util.WriteJson(w, http.StatusForbidden, util.Envelope{})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
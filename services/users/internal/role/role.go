@@ -0,0 +1,58 @@
+package role
+
+import (
+	"strings"
+)
+
+const (
+	ROLE_REGULAR   = "regular"
+	ROLE_MODERATOR = "moderator"
+	ROLE_ADMIN     = "admin"
+)
+
+const (
+	SCOPE_USERS_ADMIN = "users:admin"
+)
+
+// Scopes granted to each role. The gateway matches these against the scopes
+// required by its routes, "resource:*" grants every action on a resource.
+var roleScopes = map[string][]string{
+	ROLE_REGULAR: {
+		"anime:read",
+		"progress:read",
+		"progress:write",
+	},
+	ROLE_MODERATOR: {
+		"anime:read",
+		"anime:write",
+		"progress:read",
+		"progress:write",
+	},
+	ROLE_ADMIN: {
+		"anime:*",
+		"progress:*",
+		SCOPE_USERS_ADMIN,
+	},
+}
+
+// Users who have not verified their email may only read, whatever their role.
+var unverifiedScopes = []string{
+	"anime:read",
+	"progress:read",
+}
+
+func Valid(role string) bool {
+	_, ok := roleScopes[role]
+	return ok
+}
+
+// Scope returns the JWT scope claim for role. Unknown roles get no scopes.
+func Scope(role string) string {
+	return strings.Join(roleScopes[role], ",")
+}
+
+// UnverifiedScope returns the JWT scope claim for users who have not verified
+// their email.
+func UnverifiedScope() string {
+	return strings.Join(unverifiedScopes, ",")
+}
@@ -0,0 +1,27 @@
+package role
+
+import (
+	"testing"
+
+	"github.com/JustinLi007/whatdoing/libs/go/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScope(t *testing.T) {
+	assert.True(t, util.HasScope("anime:read", Scope(ROLE_REGULAR)))
+	assert.False(t, util.HasScope("anime:write", Scope(ROLE_REGULAR)))
+	assert.False(t, util.HasScope(SCOPE_USERS_ADMIN, Scope(ROLE_MODERATOR)))
+
+	assert.True(t, util.HasScope("anime:write", Scope(ROLE_MODERATOR)))
+
+	assert.True(t, util.HasScope("anime:write", Scope(ROLE_ADMIN)))
+	assert.True(t, util.HasScope(SCOPE_USERS_ADMIN, Scope(ROLE_ADMIN)))
+
+	assert.Equal(t, "", Scope("root"))
+	assert.False(t, Valid("root"))
+}
+
+func TestUnverifiedScope(t *testing.T) {
+	assert.True(t, util.HasScope("anime:read", UnverifiedScope()))
+	assert.False(t, util.HasScope("progress:write", UnverifiedScope()))
+}
@@ -3,24 +3,64 @@ package server
 import (
 	"net/http"
 
+	"github.com/JustinLi007/whatdoing/libs/go/requestid"
 	"github.com/JustinLi007/whatdoing/libs/go/util"
+	"github.com/JustinLi007/whatdoing/services/users/internal/role"
 
 	"github.com/go-chi/chi/v5"
 )
 
 func (s *Server) RegisterRoutes() *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(requestid.Middleware)
 
-	// r.Use(s.Middleware.Cors)
+	r.Use(s.Middleware.Cors)
 
-	// r.Group(func(r chi.Router) {
-	// 	r.Post("/auth/signup", s.HandlerUsers.SignUp)
-	// 	r.Post("/auth/login", s.HandlerUsers.Login)
-	// 	r.Post("/auth/logout", s.HandlerUsers.Logout)
-	// 	r.Post("/auth/refresh", s.HandlerUsers.Refresh)
-	// })
+	r.Group(func(r chi.Router) {
+		r.Post("/auth/signup", s.HandlerUsers.SignUp)
+		r.Post("/auth/login", s.HandlerUsers.Login)
+		r.Post("/auth/logout", s.HandlerUsers.Logout)
+		r.Post("/auth/refresh", s.HandlerUsers.Refresh)
+		r.Post("/auth/email/verify", s.HandlerUsers.VerifyEmail)
+		r.Post("/auth/password/forgot", s.HandlerUsers.ForgotPassword)
+		r.Post("/auth/password/reset", s.HandlerUsers.ResetPassword)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.Middleware.RequireUser)
+		r.Get("/auth/sessions", s.HandlerUsers.GetSessions)
+		r.Delete("/auth/sessions", s.HandlerUsers.RevokeSessions)
+		r.Delete("/auth/sessions/{id}", s.HandlerUsers.RevokeSession)
+		r.Post("/auth/email/verify/resend", s.HandlerUsers.ResendVerification)
+		r.Get("/auth/me", s.HandlerUsers.GetMe)
+		r.Patch("/auth/me", s.HandlerUsers.UpdateMe)
+		r.Delete("/auth/me", s.HandlerUsers.DeleteMe)
+		r.Post("/auth/me/password", s.HandlerUsers.ChangePassword)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.Middleware.RequireScope(role.SCOPE_USERS_ADMIN))
+		r.Put("/auth/admin/users/{id}/role", s.HandlerUsers.UpdateRole)
+		r.Get("/auth/admin/keys", s.HandlerSigner.GetKeys)
+		r.Post("/auth/admin/keys/rotate", s.HandlerSigner.RotateKeys)
+	})
+
+	// The gateway forwards the auth prefix unstripped, clients fetch the set
+	// there. The root path stays for services that reach us directly.
+	r.Get("/auth/.well-known/jwks.json", s.HandlerSigner.GetJwks)
+	r.Get("/.well-known/jwks.json", s.HandlerSigner.GetJwks)
+
+	r.Get("/healthz", s.Healthz)
+
+	return r
+}
+
+// RegisterInternalRoutes serves the routes only other services may call.
+func (s *Server) RegisterInternalRoutes() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(requestid.Middleware)
 
-	// r.Get("/.well-known/jwks.json", s.HandlerSigner.GetJwks)
+	r.Get("/internal/revoked-tokens", s.HandlerUsers.GetRevokedTokens)
 
 	r.Get("/healthz", s.Healthz)
 
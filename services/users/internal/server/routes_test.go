@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/JustinLi007/whatdoing/libs/go/cors"
+	"github.com/JustinLi007/whatdoing/services/users/internal/handlers"
+	"github.com/JustinLi007/whatdoing/services/users/internal/middleware"
+	"github.com/JustinLi007/whatdoing/services/users/internal/signer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJwksBehindAuthPrefix(t *testing.T) {
+	t.Setenv("BASE64_SEED", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	jwtSigner, err := signer.NewSigner("iss", "aud", "", "", 0)
+	require.NoError(t, err)
+
+	s := &Server{
+		Middleware:    middleware.NewMiddleware(&cors.Policy{}),
+		HandlerSigner: handlers.NewHandlerSigner(jwtSigner),
+		HandlerUsers:  handlers.NewHandlerUsers(jwtSigner, nil, nil, ""),
+	}
+	router := s.RegisterRoutes()
+
+	for _, path := range []string{"/auth/.well-known/jwks.json", "/.well-known/jwks.json"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, rr.Code, path)
+
+		var set struct {
+			Keys []map[string]any `json:"keys"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &set), path)
+		assert.Len(t, set.Keys, 1, path)
+	}
+}
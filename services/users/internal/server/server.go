@@ -3,20 +3,33 @@ package server
 import (
 	"context"
 	"fmt"
-	_ "log"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/JustinLi007/whatdoing/libs/go/config"
-	_ "github.com/JustinLi007/whatdoing/libs/go/util"
-	_ "github.com/JustinLi007/whatdoing/services/users/internal/database"
+	"github.com/JustinLi007/whatdoing/libs/go/cors"
+	"github.com/JustinLi007/whatdoing/libs/go/util"
+	"github.com/JustinLi007/whatdoing/services/users/internal/database"
 	"github.com/JustinLi007/whatdoing/services/users/internal/handlers"
+	"github.com/JustinLi007/whatdoing/services/users/internal/mailer"
 	"github.com/JustinLi007/whatdoing/services/users/internal/middleware"
-	_ "github.com/JustinLi007/whatdoing/services/users/internal/signer"
-	_ "github.com/JustinLi007/whatdoing/services/users/migrations"
+	"github.com/JustinLi007/whatdoing/services/users/internal/signer"
+	"github.com/JustinLi007/whatdoing/services/users/migrations"
+)
+
+const (
+	DEFAULT_PORT          = 8080
+	DEFAULT_INTERNAL_PORT = 8081
+	DEFAULT_APP_URL       = "http://localhost:5173"
 )
 
 type Server struct {
-	Port          int
+	Port int
+	// InternalPort serves the routes other services call directly, it must
+	// not be reachable through the gateway.
+	InternalPort  int
 	Iss           string
 	Aud           string
 	Middleware    middleware.Middleware
@@ -24,47 +37,94 @@ type Server struct {
 	HandlerUsers  handlers.HandlerUsers
 }
 
-func NewServer(ctx context.Context, c *config.Config) *http.Server {
-	server := &Server{}
+// NewServer returns the public server and the internal one.
+func NewServer(ctx context.Context, c *config.Config) (*http.Server, *http.Server) {
+	server := &Server{
+		Port:         DEFAULT_PORT,
+		InternalPort: DEFAULT_INTERNAL_PORT,
+	}
+
+	if v := c.Get("SERVER_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		util.RequireNoError(err, "error: failed to parse port")
+		server.Port = port
+	}
+
+	if v := c.Get("INTERNAL_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		util.RequireNoError(err, "error: failed to parse internal port")
+		server.InternalPort = port
+	}
+	if server.InternalPort == server.Port {
+		log.Fatalf("error: %v", fmt.Errorf("INTERNAL_PORT must differ from SERVER_PORT"))
+	}
 
 	server.Iss = c.Get("JWT_ISSUER")
 	server.Aud = c.Get("JWT_AUDIENCE")
+	if server.Iss == "" || server.Aud == "" {
+		log.Fatalf("error: %v", fmt.Errorf("JWT_ISSUER and JWT_AUDIENCE must be set"))
+	}
+
+	// database
+	connStr := c.Get("DB_URL")
+	if connStr == "" {
+		log.Fatalf("error: %v", fmt.Errorf("invalid conn str"))
+	}
+
+	db, err := database.NewDb(connStr)
+	util.RequireNoError(err, "error: service failed to connect to db")
+
+	if err := db.MigrateFS(migrations.Fs, "."); err != nil {
+		log.Fatalf("error: %v", err)
+	}
 
-	// // database
-	// connStr := c.Get("DB_URL")
-	// if connStr == "" {
-	// 	log.Fatalf("error: %v", fmt.Errorf("invalid conn str"))
-	// }
-	//
-	// db, err := database.NewDb(connStr)
-	// util.RequireNoError(err, "error: service failed to connect to db")
-	//
-	// if err := db.MigrateFS(migrations.Fs, "."); err != nil {
-	// 	log.Fatalf("error: %v", err)
-	// }
-	//
-	// // middleware
-	// middleware := middleware.NewMiddleware()
-	//
-	// // signer
-	// signer, err := signer.NewSigner(server.Iss, server.Aud)
-	// if err != nil {
-	// 	log.Fatalf("error: %v", err)
-	// }
-	//
-	// // services
-	// usersService := database.NewServiceUsers(db)
-	//
-	// // handlers
-	// signerHandler := handlers.NewHandlerSigner(signer)
-	// usersHandler := handlers.NewHandlerUsers(signer, usersService)
-	//
-	// server.Middleware = middleware
-	// server.HandlerSigner = signerHandler
-	// server.HandlerUsers = usersHandler
-
-	return &http.Server{
-		Addr:    fmt.Sprintf(":%d", 8080),
+	// middleware
+	corsPolicy, err := cors.NewPolicyFromConfig(c)
+	util.RequireNoError(err, "error: failed to load cors policy")
+
+	middleware := middleware.NewMiddleware(corsPolicy)
+
+	// signer
+	var rotationInterval time.Duration
+	if v := c.Get("SIGNING_KEY_ROTATION_INTERVAL"); v != "" {
+		rotationInterval, err = time.ParseDuration(v)
+		util.RequireNoError(err, "error: failed to parse signing key rotation interval")
+	}
+
+	signer, err := signer.NewSigner(server.Iss, server.Aud, c.Get("SIGNING_KEYS_DIR"), c.Get("SIGNING_KEYS_FILE"), rotationInterval)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	go signer.Start(ctx)
+
+	// mailer
+	mailer, err := mailer.NewMailerFromConfig(c)
+	util.RequireNoError(err, "error: failed to create mailer")
+
+	appUrl := c.Get("APP_URL")
+	if appUrl == "" {
+		appUrl = DEFAULT_APP_URL
+	}
+
+	// services
+	usersService := database.NewServiceUsers(db)
+
+	// handlers
+	signerHandler := handlers.NewHandlerSigner(signer)
+	usersHandler := handlers.NewHandlerUsers(signer, usersService, mailer, appUrl)
+
+	server.Middleware = middleware
+	server.HandlerSigner = signerHandler
+	server.HandlerUsers = usersHandler
+
+	publicServer := &http.Server{
+		Addr:    fmt.Sprintf(":%d", server.Port),
 		Handler: server.RegisterRoutes(),
 	}
+	internalServer := &http.Server{
+		Addr:    fmt.Sprintf(":%d", server.InternalPort),
+		Handler: server.RegisterInternalRoutes(),
+	}
+
+	return publicServer, internalServer
 }
@@ -0,0 +1,218 @@
+package signer
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// KeyStatus describes a key published in the jwk set.
+type KeyStatus struct {
+	Kid       string    `json:"kid"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	RetiredAt time.Time `json:"retired_at,omitzero"`
+}
+
+type signingKey struct {
+	kid       string
+	priv      ed25519.PrivateKey
+	pub       jwk.Key
+	createdAt time.Time
+	// retiredAt is when a newer key became active. Zero for the active key
+	// and for keys whose retirement is unknown, which are never pruned.
+	retiredAt time.Time
+	// path is the file the key was loaded from, if any.
+	path string
+}
+
+func newSigningKey(priv ed25519.PrivateKey, kid string, createdAt time.Time) (*signingKey, error) {
+	pub, err := jwk.Import(priv.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if kid == "" {
+		if err := jwk.AssignKeyID(pub); err != nil {
+			return nil, err
+		}
+	} else if err := pub.Set(jwk.KeyIDKey, kid); err != nil {
+		return nil, err
+	}
+
+	kid, ok := pub.KeyID()
+	if !ok {
+		return nil, fmt.Errorf(`key no "kid"`)
+	}
+
+	if err := pub.Set(jwk.AlgorithmKey, jwa.EdDSA()); err != nil {
+		return nil, err
+	}
+
+	return &signingKey{
+		kid:       kid,
+		priv:      priv,
+		pub:       pub,
+		createdAt: createdAt,
+	}, nil
+}
+
+func generateKey(now time.Time) (*signingKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(priv, "", now)
+}
+
+func keyFromSeed(base64Seed string, createdAt time.Time) (*signingKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(base64Seed))
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("seed must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	return newSigningKey(ed25519.NewKeyFromSeed(seed), "", createdAt)
+}
+
+// loadKeysDir loads every *.key file, each holding a base64 ed25519 seed.
+// Keys are ordered by modification time, the newest is active and each older
+// key retired when the next one was added.
+func loadKeysDir(dir string) ([]*signingKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*signingKey, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := keyFromSeed(string(data), info.ModTime())
+		if err != nil {
+			return nil, fmt.Errorf("key file %q: %v", path, err)
+		}
+		key.path = path
+		keys = append(keys, key)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].createdAt.Equal(keys[j].createdAt) {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].createdAt.Before(keys[j].createdAt)
+	})
+	for i := 0; i < len(keys)-1; i++ {
+		keys[i].retiredAt = keys[i+1].createdAt
+	}
+
+	return keys, nil
+}
+
+const (
+	ROTATE_LOCK_FILE = "rotate.lock"
+	// ROTATE_LOCK_TIMEOUT is how old a lock file must be before it is taken
+	// as left behind by a crashed instance. Rotating takes milliseconds.
+	ROTATE_LOCK_TIMEOUT = time.Minute
+)
+
+// lockKeysDir creates the dir's lock file, failing with ErrRotationInProgress
+// while another instance holds it. The returned func releases the lock.
+func lockKeysDir(dir string, now time.Time) (func(), error) {
+	path := filepath.Join(dir, ROTATE_LOCK_FILE)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(err) {
+		info, statErr := os.Stat(path)
+		if statErr != nil || now.Sub(info.ModTime()) < ROTATE_LOCK_TIMEOUT {
+			return nil, ErrRotationInProgress
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if os.IsExist(err) {
+			return nil, ErrRotationInProgress
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chtimes(path, now, now); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("error: signer unlock: %v", err)
+		}
+	}, nil
+}
+
+// writeKeyFile persists a generated key to dir so other instances and
+// restarts pick it up.
+func writeKeyFile(dir string, key *signingKey, now time.Time) error {
+	path := filepath.Join(dir, fmt.Sprintf("%d.key", now.UnixNano()))
+	seed := base64.StdEncoding.EncodeToString(key.priv.Seed())
+	if err := os.WriteFile(path, []byte(seed+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Chtimes(path, now, now)
+}
+
+// loadKeysFile loads a jwk set of ed25519 private keys. The last key is
+// active, the keys before it are kept until they are removed from the file.
+func loadKeysFile(path string) ([]*signingKey, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := jwk.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*signingKey, 0, set.Len())
+	for i := 0; i < set.Len(); i++ {
+		rawKey, _ := set.Key(i)
+
+		var priv ed25519.PrivateKey
+		if err := jwk.Export(rawKey, &priv); err != nil {
+			return nil, fmt.Errorf("key %d: must be an ed25519 private key: %v", i, err)
+		}
+
+		kid, _ := rawKey.KeyID()
+		key, err := newSigningKey(priv, kid, info.ModTime())
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", i, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
@@ -1,13 +1,16 @@
 package signer
 
 import (
-	"crypto/ed25519"
-	"encoding/base64"
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/JustinLi007/whatdoing/services/users/internal/token"
+
 	"github.com/google/uuid"
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwk"
@@ -15,46 +18,73 @@ import (
 	"github.com/lestrrat-go/jwx/v3/jwt"
 )
 
+const (
+	// KEY_RETENTION is how long a retired key stays published, long enough
+	// for every token it signed to expire.
+	KEY_RETENTION = token.JWT_TTL + time.Minute*5
+	// KEY_CHECK_INTERVAL is how often keys are reloaded, pruned and, when
+	// scheduled, rotated.
+	KEY_CHECK_INTERVAL = time.Minute
+)
+
+var (
+	ErrNoSigningKey       = errors.New("no signing key")
+	ErrRotationNeedsDir   = errors.New("scheduled key rotation needs a keys dir, rotated keys are lost on restart otherwise")
+	ErrRotationInProgress = errors.New("another instance is rotating the signing key")
+)
+
 type Signer interface {
-	NewJwt(sub, scope string, ttl time.Duration) (string, error)
+	NewJwt(sub, sid, role, scope string, ttl time.Duration) (string, error)
+	ParseJwt(tokenStr string) (jwt.Token, error)
 	GetJwkSet() jwk.Set
+	Keys() []KeyStatus
+	Rotate() (string, error)
+	Start(ctx context.Context)
 }
 
+// JwtSigner signs with its newest key. Older keys stay in the jwk set for
+// KEY_RETENTION after they were retired so their tokens keep verifying.
+//
+// Keys are loaded from keysDir if set, else from keysFile, else from the
+// BASE64_SEED environment variable. Only keys in keysDir survive a rotation
+// across restarts, so scheduled rotation requires it. Instances sharing
+// keysDir take a lock file in it to rotate, so only one of them adds a key
+// when the active one is due.
 type JwtSigner struct {
-	mtx      sync.RWMutex
-	priv     ed25519.PrivateKey
-	pub      ed25519.PublicKey
-	set      jwk.Set
-	issuer   string
-	audience string
-	kid      string
+	mtx              sync.RWMutex
+	keys             []*signingKey
+	set              jwk.Set
+	issuer           string
+	audience         string
+	keysDir          string
+	keysFile         string
+	rotationInterval time.Duration
+	now              func() time.Time
 }
 
 var signerInstance *JwtSigner
 
-func NewSigner(iss, aud string) (Signer, error) {
+func NewSigner(iss, aud, keysDir, keysFile string, rotationInterval time.Duration) (Signer, error) {
 	if signerInstance != nil {
 		return signerInstance, nil
 	}
 
-	base64Seed, err := base64.StdEncoding.DecodeString(os.Getenv("BASE64_SEED"))
-	if err != nil {
-		return nil, err
+	if rotationInterval > 0 && keysDir == "" {
+		return nil, ErrRotationNeedsDir
 	}
 
-	priv := ed25519.NewKeyFromSeed(base64Seed)
-	pub := priv.Public().(ed25519.PublicKey)
-
 	jwtSigner := &JwtSigner{
-		mtx:      sync.RWMutex{},
-		priv:     priv,
-		pub:      pub,
-		set:      jwk.NewSet(),
-		issuer:   iss,
-		audience: aud,
+		mtx:              sync.RWMutex{},
+		set:              jwk.NewSet(),
+		issuer:           iss,
+		audience:         aud,
+		keysDir:          keysDir,
+		keysFile:         keysFile,
+		rotationInterval: rotationInterval,
+		now:              time.Now,
 	}
 
-	if _, err := jwtSigner.createJwk(pub); err != nil {
+	if err := jwtSigner.load(); err != nil {
 		return nil, err
 	}
 
@@ -63,11 +93,16 @@ func NewSigner(iss, aud string) (Signer, error) {
 	return signerInstance, nil
 }
 
-func (s *JwtSigner) NewJwt(sub, scope string, ttl time.Duration) (string, error) {
+func (s *JwtSigner) NewJwt(sub, sid, role, scope string, ttl time.Duration) (string, error) {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
 
-	now := time.Now()
+	if len(s.keys) == 0 {
+		return "", ErrNoSigningKey
+	}
+	signingKey := s.keys[len(s.keys)-1]
+
+	now := s.now()
 	builder := jwt.NewBuilder().
 		Issuer(s.issuer).
 		Subject(sub).
@@ -76,27 +111,25 @@ func (s *JwtSigner) NewJwt(sub, scope string, ttl time.Duration) (string, error)
 		NotBefore(now.Add(time.Second*-5)).
 		Expiration(now.Add(ttl)).
 		Claim("scope", scope).
+		Claim("role", role).
 		JwtID(uuid.NewString())
+	if sid != "" {
+		builder = builder.Claim("sid", sid)
+	}
 
 	tok, err := builder.Build()
 	if err != nil {
 		return "", err
 	}
 
-	// signingKey, ok := s.set.LookupKeyID(s.kid)
-	_, ok := s.set.LookupKeyID(s.kid)
-	if !ok {
-		return "", fmt.Errorf(`no signing key`)
-	}
-
 	jwsHeaders := jws.NewHeaders()
 	jwsHeaders.Set(jws.AlgorithmKey, jwa.EdDSA())
-	jwsHeaders.Set(jws.KeyIDKey, s.kid)
+	jwsHeaders.Set(jws.KeyIDKey, signingKey.kid)
 	signedJwtBytes, err := jwt.Sign(
 		tok,
 		jwt.WithKey(
 			jwa.EdDSA(),
-			s.priv,
+			signingKey.priv,
 			jws.WithProtectedHeaders(jwsHeaders),
 		),
 	)
@@ -107,37 +140,225 @@ func (s *JwtSigner) NewJwt(sub, scope string, ttl time.Duration) (string, error)
 	return string(signedJwtBytes), nil
 }
 
+// ParseJwt verifies a token this signer issued. Expired tokens are accepted,
+// callers check the expiry themselves.
+func (s *JwtSigner) ParseJwt(tokenStr string) (jwt.Token, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return jwt.ParseString(
+		tokenStr,
+		jwt.WithKeySet(s.set),
+		jwt.WithValidate(false),
+	)
+}
+
 func (s *JwtSigner) GetJwkSet() jwk.Set {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
 	return s.set
 }
 
-func (s *JwtSigner) createJwk(pub ed25519.PublicKey) (jwk.Key, error) {
-	key, err := jwk.Import(pub)
+func (s *JwtSigner) Keys() []KeyStatus {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	result := make([]KeyStatus, 0, len(s.keys))
+	for i, key := range s.keys {
+		result = append(result, KeyStatus{
+			Kid:       key.kid,
+			Active:    i == len(s.keys)-1,
+			CreatedAt: key.createdAt,
+			RetiredAt: key.retiredAt,
+		})
+	}
+	return result
+}
+
+// Rotate makes a newly generated key the active one and returns its kid.
+func (s *JwtSigner) Rotate() (string, error) {
+	if s.keysDir == "" {
+		return s.rotate()
+	}
+
+	unlock, err := lockKeysDir(s.keysDir, s.now())
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	defer unlock()
 
-	if err := jwk.AssignKeyID(key); err != nil {
-		return nil, err
+	return s.rotate()
+}
+
+// rotateIfDue rotates when the active key is older than the rotation
+// interval. Keys are reloaded under the dir's lock first, so a key another
+// instance just added counts and is not rotated again.
+func (s *JwtSigner) rotateIfDue() error {
+	unlock, err := lockKeysDir(s.keysDir, s.now())
+	if errors.Is(err, ErrRotationInProgress) {
+		return nil
 	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	kid, ok := key.KeyID()
-	if !ok {
-		return nil, fmt.Errorf(`key no "kid"`)
+	if err := s.load(); err != nil {
+		return err
+	}
+	if s.activeAge() < s.rotationInterval {
+		return nil
 	}
 
-	if err := key.Set(jwk.AlgorithmKey, jwa.EdDSA()); err != nil {
-		return nil, err
+	_, err = s.rotate()
+	return err
+}
+
+func (s *JwtSigner) rotate() (string, error) {
+	key, err := generateKey(s.now())
+	if err != nil {
+		return "", err
+	}
+
+	if s.keysDir != "" {
+		if err := writeKeyFile(s.keysDir, key, key.createdAt); err != nil {
+			return "", err
+		}
+		if err := s.load(); err != nil {
+			return "", err
+		}
+		log.Printf("signer: rotated to key %v", key.kid)
+		return key.kid, nil
 	}
 
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
-	s.kid = kid
-	if err := s.set.AddKey(key); err != nil {
-		return nil, err
+
+	if len(s.keys) > 0 {
+		s.keys[len(s.keys)-1].retiredAt = key.createdAt
+	}
+	keys := append(append([]*signingKey{}, s.keys...), key)
+	if err := s.setKeys(keys); err != nil {
+		return "", err
+	}
+
+	log.Printf("signer: rotated to key %v, the key is lost on restart unless added to the key source", key.kid)
+	return key.kid, nil
+}
+
+// Start reloads and prunes keys, and rotates them when the active key is older
+// than the rotation interval.
+func (s *JwtSigner) Start(ctx context.Context) {
+	ticker := time.NewTicker(KEY_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if s.keysDir != "" {
+			if err := s.load(); err != nil {
+				log.Printf("error: signer reload: %v", err)
+			}
+		}
+
+		if err := s.prune(); err != nil {
+			log.Printf("error: signer prune: %v", err)
+		}
+
+		if s.rotationInterval > 0 && s.activeAge() >= s.rotationInterval {
+			if err := s.rotateIfDue(); err != nil {
+				log.Printf("error: signer rotate: %v", err)
+			}
+		}
 	}
+}
 
-	return key, nil
+func (s *JwtSigner) load() error {
+	var keys []*signingKey
+	var err error
+	switch {
+	case s.keysDir != "":
+		keys, err = loadKeysDir(s.keysDir)
+		if err == nil && len(keys) == 0 {
+			// Bootstrap an empty directory with a first key.
+			var key *signingKey
+			key, err = generateKey(s.now())
+			if err == nil {
+				err = writeKeyFile(s.keysDir, key, key.createdAt)
+			}
+			if err == nil {
+				keys, err = loadKeysDir(s.keysDir)
+			}
+		}
+	case s.keysFile != "":
+		keys, err = loadKeysFile(s.keysFile)
+	default:
+		var key *signingKey
+		key, err = keyFromSeed(os.Getenv("BASE64_SEED"), s.now())
+		keys = []*signingKey{key}
+	}
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrNoSigningKey
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.setKeys(keys)
+}
+
+// prune drops retired keys past their retention, deleting their files.
+func (s *JwtSigner) prune() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := s.now()
+	keys := make([]*signingKey, 0, len(s.keys))
+	for i, key := range s.keys {
+		expired := i < len(s.keys)-1 && !key.retiredAt.IsZero() && now.Sub(key.retiredAt) > KEY_RETENTION
+		if !expired {
+			keys = append(keys, key)
+			continue
+		}
+		if key.path != "" {
+			if err := os.Remove(key.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		log.Printf("signer: removed retired key %v", key.kid)
+	}
+
+	if len(keys) == len(s.keys) {
+		return nil
+	}
+	return s.setKeys(keys)
+}
+
+func (s *JwtSigner) activeAge() time.Duration {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if len(s.keys) == 0 {
+		return 0
+	}
+	return s.now().Sub(s.keys[len(s.keys)-1].createdAt)
+}
+
+// setKeys replaces the keys and rebuilds the published set, callers hold the
+// write lock.
+func (s *JwtSigner) setKeys(keys []*signingKey) error {
+	set := jwk.NewSet()
+	for _, key := range keys {
+		if err := set.AddKey(key.pub); err != nil {
+			return fmt.Errorf("key %v: %v", key.kid, err)
+		}
+	}
+	s.keys = keys
+	s.set = set
+	return nil
 }
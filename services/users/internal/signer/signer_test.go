@@ -0,0 +1,127 @@
+package signer
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigner(t *testing.T, keysDir string) *JwtSigner {
+	s := &JwtSigner{
+		issuer:   "iss",
+		audience: "aud",
+		keysDir:  keysDir,
+		now:      time.Now,
+	}
+	if keysDir == "" {
+		t.Setenv("BASE64_SEED", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	}
+	require.NoError(t, s.load())
+	return s
+}
+
+func TestRotateKeepsOldKeyPublished(t *testing.T) {
+	s := newTestSigner(t, "")
+
+	oldJwt, err := s.NewJwt("sub", "sid", "regular", "", time.Hour)
+	require.NoError(t, err)
+
+	kid, err := s.Rotate()
+	require.NoError(t, err)
+
+	newJwt, err := s.NewJwt("sub", "sid", "regular", "", time.Hour)
+	require.NoError(t, err)
+
+	_, err = s.ParseJwt(oldJwt)
+	assert.NoError(t, err)
+	_, err = s.ParseJwt(newJwt)
+	assert.NoError(t, err)
+
+	keys := s.Keys()
+	require.Len(t, keys, 2)
+	assert.False(t, keys[0].Active)
+	assert.False(t, keys[0].RetiredAt.IsZero())
+	assert.True(t, keys[1].Active)
+	assert.Equal(t, kid, keys[1].Kid)
+	assert.Equal(t, 2, s.GetJwkSet().Len())
+}
+
+func TestPruneRetiredKeys(t *testing.T) {
+	s := newTestSigner(t, "")
+	_, err := s.Rotate()
+	require.NoError(t, err)
+
+	require.NoError(t, s.prune())
+	assert.Len(t, s.Keys(), 2)
+
+	s.now = func() time.Time { return time.Now().Add(KEY_RETENTION + time.Minute) }
+	require.NoError(t, s.prune())
+	keys := s.Keys()
+	require.Len(t, keys, 1)
+	assert.True(t, keys[0].Active)
+}
+
+func TestKeysDir(t *testing.T) {
+	dir := t.TempDir()
+
+	// An empty directory is bootstrapped with a key.
+	s := newTestSigner(t, dir)
+	require.Len(t, s.Keys(), 1)
+
+	_, err := s.Rotate()
+	require.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// Another instance sharing the directory sees the same keys.
+	other := newTestSigner(t, dir)
+	assert.Equal(t, s.Keys(), other.Keys())
+
+	s.now = func() time.Time { return time.Now().Add(KEY_RETENTION + time.Minute) }
+	require.NoError(t, s.prune())
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestRotationNeedsKeysDir(t *testing.T) {
+	_, err := NewSigner("iss", "aud", "", "keys.json", time.Hour)
+	assert.ErrorIs(t, err, ErrRotationNeedsDir)
+}
+
+func TestRotateIfDueSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestSigner(t, dir)
+	other := newTestSigner(t, dir)
+
+	later := func() time.Time { return time.Now().Add(time.Hour * 2) }
+	s.now, other.now = later, later
+	s.rotationInterval, other.rotationInterval = time.Hour, time.Hour
+
+	// Both instances find the active key due, only the first one rotates.
+	require.NoError(t, s.rotateIfDue())
+	require.NoError(t, other.rotateIfDue())
+	assert.Len(t, s.Keys(), 2)
+	assert.Equal(t, s.Keys(), other.Keys())
+
+	// A held lock skips scheduled rotation and refuses a manual one.
+	s.now = func() time.Time { return time.Now().Add(time.Hour * 4) }
+	unlock, err := lockKeysDir(dir, s.now())
+	require.NoError(t, err)
+	require.NoError(t, s.rotateIfDue())
+	assert.Len(t, s.Keys(), 2)
+	_, err = s.Rotate()
+	assert.ErrorIs(t, err, ErrRotationInProgress)
+	unlock()
+
+	// A lock left behind by a crashed instance expires.
+	_, err = lockKeysDir(dir, time.Now())
+	require.NoError(t, err)
+	_, err = lockKeysDir(dir, time.Now().Add(ROTATE_LOCK_TIMEOUT+time.Second))
+	assert.NoError(t, err)
+}
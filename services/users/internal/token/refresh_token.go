@@ -9,9 +9,18 @@ import (
 )
 
 const (
+	JWT_TTL           = time.Hour
 	REFRESH_TOKEN_TTL = time.Hour * 24
+
+	EMAIL_VERIFICATION_TTL = time.Hour * 48
+	PASSWORD_RESET_TTL     = time.Minute * 30
+	// PASSWORD_RESET_INTERVAL is the minimum time between two reset tokens of
+	// the same user.
+	PASSWORD_RESET_INTERVAL = time.Minute * 5
 )
 
+// Token is an opaque random token. Only its hash is stored, the plain text is
+// handed to the client once.
 type Token struct {
 	PlainText string    `json:"plain_text"`
 	Hash      []byte    `json:"-"`
@@ -19,46 +28,47 @@ type Token struct {
 }
 
 func NewToken(ttl time.Duration) *Token {
-	t := &Token{}
-
 	b := make([]byte, 32)
 	rand.Read(b)
 
-	hash := sha256.Sum256(b)
-
-	t.Hash = hash[:]
-	t.PlainText = base64.StdEncoding.EncodeToString(hash[:])
-	t.Expiry = time.Now().Add(ttl).UTC()
+	t := &Token{
+		PlainText: base64.RawURLEncoding.EncodeToString(b),
+		Expiry:    time.Now().Add(ttl).UTC(),
+	}
+	t.Hash = Hash(t.PlainText)
 
 	return t
 }
 
+// Hash returns the hash stored for a plain text token.
+func Hash(plainText string) []byte {
+	hash := sha256.Sum256([]byte(plainText))
+	return hash[:]
+}
+
+// Valid reports whether the plain text matches the hash.
 func (t *Token) Valid() bool {
-	return subtle.ConstantTimeCompare([]byte(t.PlainText), t.Hash) == 1
+	if t.PlainText == "" || len(t.Hash) != sha256.Size {
+		return false
+	}
+	return subtle.ConstantTimeCompare(Hash(t.PlainText), t.Hash) == 1
+}
+
+func (t *Token) Expired() bool {
+	return !time.Now().Before(t.Expiry)
 }
 
 func (t *Token) GetPlainText() string {
-	if t.PlainText != "" {
-		return t.PlainText
-	}
-	if t.Hash == nil || len(t.Hash) != 32 {
-		return ""
-	}
-	t.PlainText = base64.StdEncoding.EncodeToString(t.Hash)
 	return t.PlainText
 }
 
 func (t *Token) GetHash() []byte {
-	if t.Hash != nil && len(t.Hash) == 32 {
+	if len(t.Hash) == sha256.Size {
 		return t.Hash
 	}
 	if t.PlainText == "" {
 		return nil
 	}
-	hash, err := base64.StdEncoding.DecodeString(t.PlainText)
-	if err != nil {
-		return nil
-	}
-	t.Hash = hash
+	t.Hash = Hash(t.PlainText)
 	return t.Hash
 }
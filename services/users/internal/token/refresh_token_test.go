@@ -0,0 +1,28 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewToken(t *testing.T) {
+	a := NewToken(time.Hour)
+	b := NewToken(time.Hour)
+
+	assert.NotEqual(t, a.PlainText, b.PlainText)
+	assert.True(t, a.Valid())
+	assert.False(t, a.Expired())
+
+	// The stored hash cannot be turned back into the token.
+	assert.NotEqual(t, a.PlainText, string(a.Hash))
+	assert.Equal(t, Hash(a.PlainText), a.GetHash())
+
+	tampered := &Token{PlainText: b.PlainText, Hash: a.Hash}
+	assert.False(t, tampered.Valid())
+}
+
+func TestTokenExpired(t *testing.T) {
+	assert.True(t, NewToken(-time.Second).Expired())
+}
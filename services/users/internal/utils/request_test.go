@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIp(t *testing.T) {
+	r := httptest.NewRequest("GET", "/auth/sessions", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	assert.Equal(t, "10.0.0.2", ClientIp(r))
+
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 203.0.113.7")
+	assert.Equal(t, "203.0.113.7", ClientIp(r))
+}
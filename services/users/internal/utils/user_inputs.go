@@ -39,3 +39,21 @@ func IsValidPassword(password string) bool {
 
 	return true
 }
+
+// IsValidUsername accepts 3 to 32 letters, digits, '_', '-' and '.'.
+func IsValidUsername(username string) bool {
+	if len(username) < 3 || len(username) > 32 {
+		return false
+	}
+
+	for _, c := range username {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '_', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
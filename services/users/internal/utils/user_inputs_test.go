@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -59,3 +60,13 @@ func TestInvalidEmail7(t *testing.T) {
 	valid := IsValidEmail(email)
 	assert.False(t, valid)
 }
+
+func TestValidUsername(t *testing.T) {
+	assert.True(t, IsValidUsername("some_user.1"))
+}
+
+func TestInvalidUsername(t *testing.T) {
+	assert.False(t, IsValidUsername("ab"))
+	assert.False(t, IsValidUsername("has space"))
+	assert.False(t, IsValidUsername(strings.Repeat("a", 33)))
+}